@@ -0,0 +1,137 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// call process with a reader for each message in the files, Maildir directories and mbox files named by args,
+// logging the failures and continuing with the next message; return the number of failures
+func forEachMessage(args []string, process func(io.Reader) error) int {
+	failed := 0
+	check := func(name string, err error) {
+		if err != nil {
+			Warning("%s: %v", name, err)
+			failed++
+		}
+	}
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			check(arg, err)
+			continue
+		}
+		if info.IsDir() {
+			files, err := maildirFiles(arg)
+			check(arg, err)
+			for _, filename := range files {
+				check(filename, processFile(filename, process))
+			}
+			continue
+		}
+		data, err := os.ReadFile(arg)
+		if err != nil {
+			check(arg, err)
+			continue
+		}
+		if bytes.HasPrefix(data, []byte("From ")) {
+			for i, message := range splitMbox(data) {
+				check(fmt.Sprintf("%s message %d", arg, i+1), process(bytes.NewReader(message)))
+			}
+			continue
+		}
+		check(arg, process(bytes.NewReader(data)))
+	}
+	return failed
+}
+
+func processFile(filename string, process func(io.Reader) error) error {
+	input, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	return process(input)
+}
+
+// return the message files in the cur and new directories of a Maildir,
+// with those found before a directory that could not be read
+func maildirFiles(dir string) ([]string, error) {
+	files := []string{}
+	for _, subdir := range []string{"cur", "new"} {
+		entries, err := os.ReadDir(filepath.Join(dir, subdir))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return files, err
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				files = append(files, filepath.Join(dir, subdir, entry.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+// split an mbox file into messages, removing the "From " separator lines
+func splitMbox(data []byte) [][]byte {
+	messages := [][]byte{}
+	var message []byte
+	lines := bufio.NewScanner(bytes.NewReader(data))
+	lines.Buffer(make([]byte, 64*1024), len(data)+1)
+	for lines.Scan() {
+		line := lines.Bytes()
+		if bytes.HasPrefix(line, []byte("From ")) {
+			if message != nil {
+				messages = append(messages, message)
+			}
+			message = []byte{}
+			continue
+		}
+		if message == nil {
+			continue
+		}
+		message = append(message, line...)
+		message = append(message, '\n')
+	}
+	if message != nil {
+		messages = append(messages, message)
+	}
+	return messages
+}
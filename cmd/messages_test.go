@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitMbox(t *testing.T) {
+	mbox := "From a@example.com Mon Oct 19 00:00:00 2026\nFrom: a@example.com\n\none\n\n" +
+		"From b@example.com Mon Oct 19 00:00:01 2026\nFrom: b@example.com\n\ntwo\n"
+	messages := splitMbox([]byte(mbox))
+	require.Len(t, messages, 2)
+	require.Equal(t, "From: a@example.com\n\none\n\n", string(messages[0]))
+	require.Equal(t, "From: b@example.com\n\ntwo\n", string(messages[1]))
+}
+
+func TestForEachMessage(t *testing.T) {
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad")
+	good := filepath.Join(dir, "good")
+	require.Nil(t, os.WriteFile(bad, []byte("From: bad@example.com\n\nbad\n"), 0600))
+	require.Nil(t, os.WriteFile(good, []byte("From: good@example.com\n\ngood\n"), 0600))
+	processed := []string{}
+	// a Maildir whose cur directory cannot be read is a failure, not an exit
+	maildir := filepath.Join(dir, "maildir")
+	require.Nil(t, os.MkdirAll(filepath.Join(maildir, "new"), 0700))
+	require.Nil(t, os.WriteFile(filepath.Join(maildir, "cur"), []byte{}, 0600))
+	failed := forEachMessage([]string{bad, filepath.Join(dir, "missing"), maildir, good}, func(input io.Reader) error {
+		data, err := io.ReadAll(input)
+		require.Nil(t, err)
+		processed = append(processed, string(data))
+		if string(data) == "From: bad@example.com\n\nbad\n" {
+			return errors.New("rejected")
+		}
+		return nil
+	})
+	require.Equal(t, 3, failed)
+	require.Len(t, processed, 2)
+}
@@ -50,10 +50,12 @@ Read environment vars set by sieve filter:
     HOME, USER, SENDER, RECIPIENT, ORIG_RECIPIENT
 Perform filterbook lookup on SENDER and set header if a match is found:
     X-Filter-Book: <bookname>
-Do not change messages matching these conditions:
+Do not change messages matching a skip rule; the default rules skip:
     message has a header "X-Filterctl-Request-Id"
-    SENDER matches MAILER-DAEMON.*
-    SENDER matches SIEVE-DAEMON.*
+    SENDER matches MAILER-DAEMON@*
+    SENDER matches SIEVE-DAEMON@*
+Use 'filterbooks rules' to list the rules and 'filterbooks rules test'
+to check a message against them.
 
 Determine the filterbook lookup address as follows:
     username is $USER
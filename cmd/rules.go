@@ -0,0 +1,103 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+
+	"github.com/rstms/filterbooks/scanner"
+	"github.com/spf13/cobra"
+)
+
+var rulesCmd = &cobra.Command{
+	Use:   "rules",
+	Short: "list message rules",
+	Long: `
+Validate the configured message rules and write them to stdout as YAML.
+When the config has no rules section the default rules are listed.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		_, err := scanner.LoadRules()
+		cobra.CheckErr(err)
+		configs, err := scanner.RuleConfigs()
+		cobra.CheckErr(err)
+		fmt.Print(FormatYAML(configs))
+	},
+}
+
+var rulesTestCmd = &cobra.Command{
+	Use:   "test [MESSAGE_FILE]",
+	Short: "evaluate message rules",
+	Long: `
+Read a message from MESSAGE_FILE or stdin and report which rules match and
+the resulting action.  No filterctld lookup is performed.  The envelope
+sender and recipient default to $SENDER and $RECIPIENT.
+`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if sender := ViperGetString("test.sender"); sender != "" {
+			ViperSet("sender", sender)
+		}
+		if recipient := ViperGetString("test.recipient"); recipient != "" {
+			ViperSet("recipient", recipient)
+		}
+		if ViperGetString("host") == "" {
+			hostname, err := os.Hostname()
+			cobra.CheckErr(err)
+			ViperSet("host", hostname)
+		}
+		if ViperGetString("user") == "" {
+			u, err := user.Current()
+			cobra.CheckErr(err)
+			ViperSet("user", u.Username)
+		}
+		input := os.Stdin
+		if len(args) > 0 {
+			var err error
+			input, err = os.Open(args[0])
+			cobra.CheckErr(err)
+			defer input.Close()
+		}
+		ruleScanner, err := scanner.NewScanner(ViperGetString("filterctld_url"), os.Stdout, input)
+		cobra.CheckErr(err)
+		err = ruleScanner.TestRules(os.Stdout)
+		cobra.CheckErr(err)
+	},
+}
+
+func init() {
+	CobraAddCommand(rootCmd, rootCmd, rulesCmd)
+	CobraAddCommand(rootCmd, rulesCmd, rulesTestCmd)
+	OptionString(rulesTestCmd, "sender", "", "", "envelope sender")
+	OptionString(rulesTestCmd, "recipient", "", "", "envelope recipient")
+}
@@ -0,0 +1,80 @@
+/*
+Copyright © 2025 Matt Krueger <mkrueger@rstms.net>
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+ 1. Redistributions of source code must retain the above copyright notice,
+    this list of conditions and the following disclaimer.
+
+ 2. Redistributions in binary form must reproduce the above copyright notice,
+    this list of conditions and the following disclaimer in the documentation
+    and/or other materials provided with the distribution.
+
+ 3. Neither the name of the copyright holder nor the names of its contributors
+    may be used to endorse or promote products derived from this software
+    without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+POSSIBILITY OF SUCH DAMAGE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rstms/filterbooks/scanner"
+	"github.com/spf13/cobra"
+)
+
+var sentCmd = &cobra.Command{
+	Use:   "sent [MESSAGE_FILE|MAILDIR|MBOX...]",
+	Short: "record sent messages",
+	Long: `
+Record the Message-Id of messages sent by the user in the local store so
+that replies can be recognized with reply.check enabled.  With no arguments
+read a message from stdin and write it unchanged to stdout, for use as a
+filter on outgoing mail.  Otherwise record each MESSAGE_FILE, each message
+in the cur and new directories of each MAILDIR, and each message of each
+MBOX file.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if ViperGetString("sender") == "" {
+			ViperSet("sender", ViperGetString("user"))
+		}
+		url := ViperGetString("filterctld_url")
+		if len(args) == 0 {
+			sentScanner, err := scanner.NewScanner(url, os.Stdout, os.Stdin)
+			cobra.CheckErr(err)
+			defer sentScanner.Close()
+			cobra.CheckErr(sentScanner.ScanSent())
+			return
+		}
+		failed := forEachMessage(args, func(input io.Reader) error {
+			sentScanner, err := scanner.NewScanner(url, io.Discard, input)
+			if err != nil {
+				return err
+			}
+			return sentScanner.ScanSent()
+		})
+		if failed > 0 {
+			cobra.CheckErr(fmt.Errorf("%d messages failed", failed))
+		}
+	},
+}
+
+func init() {
+	CobraAddCommand(rootCmd, rootCmd, sentCmd)
+}
@@ -0,0 +1,103 @@
+// per-book output actions
+package scanner
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+type BookAction struct {
+	Folder     string   `mapstructure:"folder"`
+	Keywords   []string `mapstructure:"keywords"`
+	Importance string   `mapstructure:"importance"`
+	SpamAdjust float64  `mapstructure:"spam_adjust"`
+}
+
+// the X-Priority value written for each importance
+var IMPORTANCE_PRIORITY = map[string]string{
+	"high":   "1 (Highest)",
+	"normal": "3 (Normal)",
+	"low":    "5 (Lowest)",
+}
+
+func validateBookActions(actions map[string]BookAction) error {
+	for book, action := range actions {
+		if action.Importance != "" {
+			if _, ok := IMPORTANCE_PRIORITY[strings.ToLower(action.Importance)]; !ok {
+				return Fatalf("books.actions.%s.importance: expected high, normal or low, got '%s'", book, action.Importance)
+			}
+		}
+		if strings.ContainsAny(action.Folder, "\r\n") {
+			return Fatalf("books.actions.%s.folder: invalid folder %q", book, action.Folder)
+		}
+		for _, keyword := range action.Keywords {
+			if keyword == "" || strings.ContainsAny(keyword, " \t\r\n,()") {
+				return Fatalf("books.actions.%s.keywords: invalid keyword '%s'", book, keyword)
+			}
+		}
+	}
+	return nil
+}
+
+// remove the header lines of the named fields, with their continuations, from the output header
+func (s *Scanner) removeHeaderFields(names ...string) {
+	header := []string{}
+	dropping := false
+	for _, line := range s.header {
+		if dropping && line != "" && (line[0] == ' ' || line[0] == '\t') {
+			continue
+		}
+		dropping = false
+		lowLine := strings.ToLower(line)
+		for _, name := range names {
+			if strings.HasPrefix(lowLine, name+":") {
+				dropping = true
+			}
+		}
+		if dropping {
+			if s.verbose {
+				log.Printf("removing: %s\n", line)
+			}
+			continue
+		}
+		header = append(header, line)
+	}
+	s.header = header
+}
+
+// write the headers configured for the primary book above any inbound values, which may be
+// covered by a DKIM signature and are only removed with books.replace_headers
+func (s *Scanner) applyBookActions() {
+	if s.Book == "" {
+		return
+	}
+	action, ok := s.booksConfig.Actions[strings.ToLower(s.Book)]
+	if !ok {
+		return
+	}
+	if s.verbose {
+		log.Printf("book %s actions: %+v\n", s.Book, action)
+	}
+	if action.Folder != "" {
+		s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Folder: %s", action.Folder))
+	}
+	if len(action.Keywords) > 0 {
+		if s.booksConfig.ReplaceHeaders {
+			s.removeHeaderFields("x-keywords")
+		}
+		s.AddHeaderLine(fmt.Sprintf("X-Keywords: %s", strings.Join(action.Keywords, ", ")))
+	}
+	if action.Importance != "" {
+		importance := strings.ToLower(action.Importance)
+		if s.booksConfig.ReplaceHeaders {
+			s.removeHeaderFields("importance", "x-priority")
+		}
+		s.AddHeaderLine(fmt.Sprintf("X-Priority: %s", IMPORTANCE_PRIORITY[importance]))
+		s.AddHeaderLine(fmt.Sprintf("Importance: %s", importance))
+	}
+	if action.SpamAdjust != 0 {
+		s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Spam-Adjust: %s", strconv.FormatFloat(action.SpamAdjust, 'f', -1, 64)))
+	}
+}
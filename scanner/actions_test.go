@@ -0,0 +1,65 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestBookActionsConfig(t *testing.T) {
+	initTestConfig(t)
+	defer ViperSet("books", nil)
+	ViperSet("books.actions", map[string]any{"family": map[string]any{"importance": "urgent"}})
+	_, err := LoadBooksConfig()
+	require.NotNil(t, err)
+	ViperSet("books.actions", map[string]any{"family": map[string]any{"keywords": []string{"two words"}}})
+	_, err = LoadBooksConfig()
+	require.NotNil(t, err)
+	ViperSet("books.actions", map[string]any{"family": map[string]any{"folder": "INBOX\r\nX-Whitelisted: yes"}})
+	_, err = LoadBooksConfig()
+	require.ErrorContains(t, err, "invalid folder")
+}
+
+func TestBookActions(t *testing.T) {
+	initTestConfig(t)
+	ViperSet("books.actions", map[string]any{
+		"family": map[string]any{
+			"folder":     "INBOX/Family",
+			"keywords":   []string{"$family", "important"},
+			"importance": "High",
+		},
+		"newsletters": map[string]any{"spam_adjust": -2.5},
+	})
+	defer ViperSet("books", nil)
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/jane@example.com/":  scanResponse("family", "family"),
+		"/filterctl/scan/owner@example.org/news@shop.example/": scanResponse("newsletters", "newsletters"),
+	}}
+
+	message := "From: jane@example.com\nX-Priority: 5\n\tfolded\nImportance: low\nX-Keywords: forged\nSubject: hi\n\nbody\n"
+	scanner, output := newTestScanner(t, message, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "INBOX/Family", outputHeader(output, "X-FilterBooks-Folder"))
+	require.Equal(t, "hi", outputHeader(output, "Subject"))
+
+	// the inbound values are kept below the new ones
+	require.Equal(t, []string{"$family, important", "forged"}, outputHeaders(output.String(), "X-Keywords"))
+	require.Equal(t, []string{"high", "low"}, outputHeaders(output.String(), "Importance"))
+	require.Equal(t, []string{"1 (Highest)", "5"}, outputHeaders(output.String(), "X-Priority"))
+	require.Contains(t, output.String(), "X-Priority: 5\n\tfolded\n")
+
+	// books.replace_headers removes them
+	ViperSet("books.replace_headers", true)
+	scanner, output = newTestScanner(t, message, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, []string{"$family, important"}, outputHeaders(output.String(), "X-Keywords"))
+	require.Equal(t, []string{"high"}, outputHeaders(output.String(), "Importance"))
+	require.Equal(t, []string{"1 (Highest)"}, outputHeaders(output.String(), "X-Priority"))
+	require.NotContains(t, output.String(), "folded")
+	require.Equal(t, "hi", outputHeader(output, "Subject"))
+
+	scanner, output = newTestScanner(t, "From: news@shop.example\nImportance: low\n\nbody\n", client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "-2.5", outputHeader(output, "X-FilterBooks-Spam-Adjust"))
+	require.Equal(t, "low", outputHeader(output, "Importance"))
+	require.Equal(t, "", outputHeader(output, "X-FilterBooks-Folder"))
+}
@@ -0,0 +1,47 @@
+// comparison of the envelope recipient with the To and Cc addresses
+package scanner
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+)
+
+// remove a +detail sub-address from the local part of an address
+func baseAddress(address string) string {
+	local, domain, found := strings.Cut(strings.ToLower(address), "@")
+	if !found {
+		return strings.ToLower(address)
+	}
+	local, _, _ = strings.Cut(local, "+")
+	return local + "@" + domain
+}
+
+// return true if list contains the address, ignoring sub-address details
+func containsAddress(list []string, address string) bool {
+	address = baseAddress(address)
+	return slices.ContainsFunc(list, func(a string) bool { return baseAddress(a) == address })
+}
+
+// report whether the envelope recipient was addressed in To, in Cc, or only by BCC or an alias
+func (s *Scanner) checkAddressed() {
+	s.ToList = parseAddressList(strings.Join(s.HeaderValues("to"), ","))
+	s.CcList = parseAddressList(strings.Join(s.HeaderValues("cc"), ","))
+	recipient := strings.Trim(strings.TrimSpace(s.Recipient), "<>")
+	if recipient == "" {
+		recipient = s.Address
+	}
+	switch {
+	case containsAddress(s.ToList, recipient):
+		s.Addressed = "to"
+	case containsAddress(s.CcList, recipient):
+		s.Addressed = "cc"
+	default:
+		s.Addressed = "bcc"
+	}
+	if s.verbose {
+		log.Printf("recipient %s addressed by %s\n", recipient, s.Addressed)
+	}
+	s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Addressed: %s", s.Addressed))
+}
@@ -0,0 +1,30 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestBaseAddress(t *testing.T) {
+	require.Equal(t, "owner@example.org", baseAddress("Owner+Shop@Example.org"))
+	require.Equal(t, "owner@example.org", baseAddress("owner@example.org"))
+}
+
+func TestAddressed(t *testing.T) {
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/stranger@example.net/": scanResponse(""),
+	}}
+	for _, test := range []struct{ header, recipient, expected string }{
+		{"To: Owner <owner@example.org>, other@example.net\n", "", "to"},
+		{"To: a@example.net\nCc: b@example.net,\n\tOWNER@example.org\n", "", "cc"},
+		{"To: a@example.net\nTo: owner+list@example.org\n", "", "to"},
+		{"To: undisclosed-recipients:;\n", "", "bcc"},
+		{"To: owner@example.org\n", "<alias@example.org>", "bcc"},
+		{"To: alias@example.org\n", "alias@example.org", "to"},
+	} {
+		scanner, output := newTestScanner(t, "From: stranger@example.net\n"+test.header+"\nbody\n", client)
+		scanner.Recipient = test.recipient
+		require.Nil(t, scanner.Scan())
+		require.Equal(t, test.expected, outputHeader(output, "X-FilterBooks-Addressed"), test.header)
+	}
+}
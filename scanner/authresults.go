@@ -0,0 +1,275 @@
+// Authentication-Results parsing and whitelist gating
+package scanner
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+)
+
+const AUTH_METHODS = "spf dkim dmarc"
+
+type AuthResult struct {
+	Method string
+	Result string
+	Props  map[string]string
+}
+
+type AuthResults struct {
+	AuthservId string
+	Results    []*AuthResult
+}
+
+type AuthConfig struct {
+	AuthservId []string `mapstructure:"authserv_id"`
+	Require    []string `mapstructure:"require"`
+	Mode       string   `mapstructure:"mode"`
+}
+
+func LoadAuthConfig() (*AuthConfig, error) {
+	var config AuthConfig
+	err := viperUnmarshal("auth", &config)
+	if err != nil {
+		return nil, err
+	}
+	for _, method := range config.Require {
+		if !slices.Contains(strings.Fields(AUTH_METHODS), method) {
+			return nil, Fatalf("auth.require: unknown method '%s'", method)
+		}
+	}
+	switch config.Mode {
+	case "":
+		config.Mode = "any"
+	case "any", "all":
+	default:
+		return nil, Fatalf("auth.mode: expected 'any' or 'all', got '%s'", config.Mode)
+	}
+	for i, id := range config.AuthservId {
+		config.AuthservId[i] = strings.ToLower(id)
+	}
+	// a dkim requirement can be met by local verification without a trusted authserv_id
+	localOnly := ViperGetBool("dkim.verify") && !slices.ContainsFunc(config.Require, func(method string) bool { return method != "dkim" })
+	if len(config.Require) > 0 && len(config.AuthservId) == 0 && !localOnly {
+		return nil, Fatalf("auth.require is set but auth.authserv_id is empty")
+	}
+	return &config, nil
+}
+
+// remove RFC 5322 comments, which may nest
+func stripComments(value string) string {
+	var b strings.Builder
+	depth := 0
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '\\' && i+1 < len(value):
+			if depth == 0 {
+				b.WriteByte(c)
+				b.WriteByte(value[i+1])
+			}
+			i++
+			continue
+		case c == '"' && depth == 0:
+			quoted = !quoted
+		case c == '(' && !quoted:
+			depth++
+			continue
+		case c == ')' && !quoted && depth > 0:
+			depth--
+			continue
+		}
+		if depth == 0 {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// parse an Authentication-Results header value as described in RFC 8601
+func ParseAuthResults(value string) (*AuthResults, error) {
+	segments := strings.Split(stripComments(value), ";")
+	fields := strings.Fields(segments[0])
+	if len(fields) == 0 {
+		return nil, Fatalf("missing authserv-id: %s", value)
+	}
+	results := AuthResults{AuthservId: strings.ToLower(fields[0]), Results: []*AuthResult{}}
+	for _, segment := range segments[1:] {
+		fields := strings.Fields(segment)
+		if len(fields) == 0 || strings.ToLower(fields[0]) == "none" {
+			continue
+		}
+		method, result, found := strings.Cut(fields[0], "=")
+		if !found {
+			return nil, Fatalf("invalid resinfo '%s'", strings.TrimSpace(segment))
+		}
+		method, _, _ = strings.Cut(method, "/")
+		r := AuthResult{
+			Method: strings.ToLower(method),
+			Result: strings.ToLower(result),
+			Props:  make(map[string]string),
+		}
+		for _, field := range fields[1:] {
+			key, value, found := strings.Cut(field, "=")
+			if found {
+				r.Props[strings.ToLower(key)] = strings.Trim(value, `"`)
+			}
+		}
+		results.Results = append(results.Results, &r)
+	}
+	return &results, nil
+}
+
+func addressDomain(address string) string {
+	_, domain, found := strings.Cut(address, "@")
+	if !found {
+		return strings.ToLower(address)
+	}
+	return strings.ToLower(domain)
+}
+
+// second-level labels under which country code domains register names, as in example.co.uk
+const REGISTRY_LABELS = "ac co com edu go gob gov govt ltd mil ne net nic or org plc sch"
+
+// return the registered domain of domain, or an empty string for a public suffix or single label;
+// a heuristic standing in for the public suffix list
+func organizationalDomain(domain string) string {
+	labels := strings.Split(strings.Trim(strings.ToLower(domain), "."), ".")
+	if len(labels) < 2 || slices.Contains(labels, "") {
+		return ""
+	}
+	count := 2
+	if len(labels[len(labels)-1]) == 2 && slices.Contains(strings.Fields(REGISTRY_LABELS), labels[len(labels)-2]) {
+		count = 3
+	}
+	if len(labels) < count {
+		return ""
+	}
+	return strings.Join(labels[len(labels)-count:], ".")
+}
+
+// relaxed identifier alignment: domain has the same organizational domain as fromDomain
+func domainAligned(domain, fromDomain string) bool {
+	organization := organizationalDomain(strings.TrimPrefix(domain, "@"))
+	return organization != "" && organization == organizationalDomain(fromDomain)
+}
+
+// return the identifier of result that must align with the From domain
+func (r *AuthResult) identifier() string {
+	switch r.Method {
+	case "spf":
+		if mailfrom := r.Props["smtp.mailfrom"]; mailfrom != "" {
+			return addressDomain(mailfrom)
+		}
+		return addressDomain(r.Props["smtp.helo"])
+	case "dkim":
+		if d := r.Props["header.d"]; d != "" {
+			return d
+		}
+		return addressDomain(r.Props["header.i"])
+	case "dmarc":
+		return r.Props["header.from"]
+	}
+	return ""
+}
+
+// return the Authentication-Results written by a trusted authserv-id above the first Received
+// header from an external relay; any below it arrived with the message and may be forged
+func (s *Scanner) trustedAuthResults() []*AuthResults {
+	trusted := []*AuthResults{}
+	for _, field := range s.fields {
+		switch strings.ToLower(field.Name) {
+		case "received":
+			if _, external := s.receivedRelay(field.Value); external {
+				return trusted
+			}
+		case "authentication-results":
+			results, err := ParseAuthResults(field.Value)
+			if err != nil {
+				Warning("Authentication-Results: %v", err)
+				continue
+			}
+			if !slices.Contains(s.authConfig.AuthservId, results.AuthservId) {
+				if s.verbose {
+					log.Printf("ignoring Authentication-Results from %s\n", results.AuthservId)
+				}
+				continue
+			}
+			trusted = append(trusted, results)
+		}
+	}
+	return trusted
+}
+
+// collect the spf, dkim and dmarc results for the From domain from trusted Authentication-Results headers
+func (s *Scanner) readAuthResults() {
+	s.Auth = make(map[string]string)
+	fromDomain := addressDomain(s.From)
+	for _, results := range s.trustedAuthResults() {
+		for _, result := range results.Results {
+			if !slices.Contains(strings.Fields(AUTH_METHODS), result.Method) {
+				continue
+			}
+			if !domainAligned(result.identifier(), fromDomain) {
+				continue
+			}
+			if s.Auth[result.Method] != "pass" {
+				s.Auth[result.Method] = result.Result
+			}
+		}
+	}
+}
+
+// return true if the From address is authenticated according to the configured policy
+func (s *Scanner) Authenticated() bool {
+	for _, method := range s.authConfig.Require {
+		pass := s.Auth[method] == "pass"
+		if pass && s.authConfig.Mode == "any" {
+			return true
+		}
+		if !pass && s.authConfig.Mode == "all" {
+			return false
+		}
+	}
+	return s.authConfig.Mode == "all"
+}
+
+func (s *Scanner) authSummary() string {
+	summary := []string{}
+	for _, method := range strings.Fields(AUTH_METHODS) {
+		result := s.Auth[method]
+		if result == "" {
+			result = "none"
+		}
+		summary = append(summary, method+"="+result)
+	}
+	return strings.Join(summary, " ")
+}
+
+// withhold an address-based whitelist or book claim when the From address is not authenticated
+func (s *Scanner) applyAuthGate() {
+	if len(s.authConfig.Require) == 0 {
+		return
+	}
+	s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Auth: %s", s.authSummary()))
+	// rule books and override addresses are the owner's own decisions and are not gated
+	if s.forced || s.Override != nil || !(s.Whitelisted || s.Book != "") || s.Authenticated() {
+		return
+	}
+	claim := []string{}
+	if s.Whitelisted {
+		claim = append(claim, "whitelisted=yes")
+	}
+	if s.Book != "" {
+		claim = append(claim, "book="+s.Book)
+	}
+	if s.verbose {
+		log.Printf("unauthenticated claim: %s %s\n", strings.Join(claim, " "), s.authSummary())
+	}
+	s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Unauthenticated: %s", strings.Join(claim, " ")))
+	s.Unauthenticated = true
+	s.Whitelisted = false
+	s.Book = ""
+	s.Books = []string{}
+}
@@ -0,0 +1,105 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseAuthResults(t *testing.T) {
+	results, err := ParseAuthResults(`MX.example.org 1; spf=pass (sender SPF authorized) smtp.mailfrom=bounce@mail.example.com;
+	  dkim=pass (2048-bit key; unprotected) header.d=example.com header.s=sel header.b="AbCd";
+	  dmarc=fail (p=reject dis=none) header.from=example.com`)
+	require.Nil(t, err)
+	require.Equal(t, "mx.example.org", results.AuthservId)
+	require.Len(t, results.Results, 3)
+	require.Equal(t, "spf", results.Results[0].Method)
+	require.Equal(t, "pass", results.Results[0].Result)
+	require.Equal(t, "mail.example.com", results.Results[0].identifier())
+	require.Equal(t, "example.com", results.Results[1].identifier())
+	require.Equal(t, "AbCd", results.Results[1].Props["header.b"])
+	require.Equal(t, "fail", results.Results[2].Result)
+
+	results, err = ParseAuthResults("mx.example.org; none")
+	require.Nil(t, err)
+	require.Empty(t, results.Results)
+}
+
+func TestDomainAligned(t *testing.T) {
+	require.True(t, domainAligned("example.com", "example.com"))
+	require.True(t, domainAligned("mail.example.com", "example.com"))
+	require.True(t, domainAligned("example.com", "news.example.com"))
+	require.True(t, domainAligned("bounce.example.co.uk", "example.co.uk"))
+	require.False(t, domainAligned("com", "example.com"))
+	require.False(t, domainAligned("co.uk", "example.co.uk"))
+	require.False(t, domainAligned("other.co.uk", "example.co.uk"))
+	require.False(t, domainAligned("example.net", "example.com"))
+	require.False(t, domainAligned("", "example.com"))
+	require.False(t, domainAligned("localhost", "localhost"))
+}
+
+func TestAuthGate(t *testing.T) {
+	initTestConfig(t)
+	ViperSet("auth", map[string]any{"authserv_id": "mx.example.org", "require": []string{"dmarc", "dkim"}})
+	defer ViperSet("auth", nil)
+
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/sender@example.com/": scanResponse("family", "family"),
+	}}
+	forged := "X-Whitelisted: yes\n" +
+		"Authentication-Results: mx.example.org; spf=pass smtp.mailfrom=example.com; dkim=fail header.d=example.com\n" +
+		"Authentication-Results: evil.example.net; dmarc=pass header.from=example.com\n" +
+		"From: sender@example.com\n\nbody\n"
+	scanner, output := newTestScanner(t, forged, client)
+	err := scanner.Scan()
+	require.Nil(t, err)
+	require.True(t, scanner.Unauthenticated)
+	require.Equal(t, "spf=pass dkim=fail dmarc=none", outputHeader(output, "X-FilterBooks-Auth"))
+	require.Equal(t, "whitelisted=yes book=family", outputHeader(output, "X-FilterBooks-Unauthenticated"))
+	require.Equal(t, "", outputHeader(output, "X-Whitelisted"))
+	require.Equal(t, "", outputHeader(output, "X-FilterBook"))
+
+	signed := "Authentication-Results: mx.example.org; dkim=pass header.d=example.com\n" +
+		"From: sender@example.com\n\nbody\n"
+	scanner, output = newTestScanner(t, signed, client)
+	err = scanner.Scan()
+	require.Nil(t, err)
+	require.False(t, scanner.Unauthenticated)
+	require.Equal(t, "yes", outputHeader(output, "X-Whitelisted"))
+	require.Equal(t, "family", outputHeader(output, "X-FilterBook"))
+
+	// a trusted header below the Received header of the external relay came with the message
+	received := "Received: from mail.example.com (mail.example.com [203.0.113.5])\n\tby mx.example.org with ESMTP id 1\n"
+	scanner, output = newTestScanner(t, received+signed, client)
+	require.Nil(t, scanner.Scan())
+	require.True(t, scanner.Unauthenticated)
+	require.Equal(t, "", outputHeader(output, "X-FilterBook"))
+	scanner, output = newTestScanner(t, "Authentication-Results: mx.example.org; dkim=pass header.d=example.com\n"+received+"From: sender@example.com\n\nbody\n", client)
+	require.Nil(t, scanner.Scan())
+	require.False(t, scanner.Unauthenticated)
+	require.Equal(t, "family", outputHeader(output, "X-FilterBook"))
+
+	// an override address is whitelisted and booked regardless of authentication
+	ViperSet("overrides", map[string]any{"books": []map[string]any{{"book": "Family", "match": []string{"sender@example.com"}}}})
+	scanner, output = newTestScanner(t, forged, client)
+	ViperSet("overrides", nil)
+	require.Nil(t, scanner.Scan())
+	require.False(t, scanner.Unauthenticated)
+	require.Equal(t, "", outputHeader(output, "X-FilterBooks-Unauthenticated"))
+	require.Equal(t, "Family", outputHeader(output, "X-FilterBook"))
+
+	ViperSet("auth", map[string]any{"require": []string{"dmarc"}})
+	_, err = LoadAuthConfig()
+	require.ErrorContains(t, err, "authserv_id is empty")
+
+	// dkim can be verified locally without a trusted authserv_id
+	ViperSet("auth", map[string]any{"require": []string{"dkim"}})
+	_, err = LoadAuthConfig()
+	require.ErrorContains(t, err, "authserv_id is empty")
+	ViperSet("dkim.verify", true)
+	defer ViperSet("dkim", nil)
+	_, err = LoadAuthConfig()
+	require.Nil(t, err)
+	ViperSet("auth", map[string]any{"require": []string{"dkim", "spf"}})
+	_, err = LoadAuthConfig()
+	require.ErrorContains(t, err, "authserv_id is empty")
+}
@@ -0,0 +1,59 @@
+// recognition of automatically generated messages
+package scanner
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var AUTO_PRECEDENCE = []string{"bulk", "junk", "list", "auto_reply"}
+
+// headers set by common vacation and auto-reply implementations
+var AUTO_REPLY_HEADERS = []string{"x-autoreply", "x-autorespond", "x-auto-reply", "x-vacation"}
+
+var AUTO_REPLY_SUBJECT = regexp.MustCompile(`(?i)^\s*(auto:|automatic reply|auto-?reply|autoresponse|out of (the )?office|vacation( reply)?:|abwesenheitsnotiz|r[ée]ponse automatique)`)
+
+// return the reasons the message appears to be automatically generated
+func (s *Scanner) autoReasons() []string {
+	reasons := []string{}
+	if value := strings.ToLower(strings.TrimSpace(stripComments(s.HeaderValue("auto-submitted")))); value != "" && value != "no" {
+		reasons = append(reasons, "auto-submitted="+value)
+	}
+	if value := strings.ToLower(strings.TrimSpace(s.HeaderValue("precedence"))); slices.Contains(AUTO_PRECEDENCE, value) {
+		reasons = append(reasons, "precedence="+value)
+	}
+	if value := strings.TrimSpace(s.HeaderValue("x-auto-response-suppress")); value != "" {
+		reasons = append(reasons, "suppress="+strings.ReplaceAll(value, " ", ""))
+	}
+	vacation := AUTO_REPLY_SUBJECT.MatchString(s.HeaderValue("subject"))
+	for _, name := range AUTO_REPLY_HEADERS {
+		if len(s.HeaderValues(name)) > 0 {
+			vacation = true
+		}
+	}
+	if vacation {
+		reasons = append(reasons, "vacation")
+	}
+	if sender := strings.TrimSpace(s.Sender); sender == "<>" {
+		reasons = append(reasons, "null-sender")
+	}
+	return reasons
+}
+
+// tag automatically generated messages, skipping their lookup if so configured
+func (s *Scanner) checkAuto() {
+	s.Auto = s.autoReasons()
+	if len(s.Auto) == 0 {
+		return
+	}
+	if s.verbose {
+		log.Printf("auto: %s\n", strings.Join(s.Auto, " "))
+	}
+	s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Auto: %s", strings.Join(s.Auto, " ")))
+	if ViperGetBool("auto.skip_lookup") && !s.forced {
+		s.skip = true
+	}
+}
@@ -0,0 +1,48 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestAutoReasons(t *testing.T) {
+	scanner, _ := newTestScanner(t, "Auto-Submitted: auto-replied (vacation)\nPrecedence: Bulk\nX-Auto-Response-Suppress: DR, OOF\nSubject: Out of Office: meeting\n\nbody\n", nil)
+	require.Nil(t, scanner.ReadHeader())
+	require.Equal(t, []string{"auto-submitted=auto-replied", "precedence=bulk", "suppress=DR,OOF", "vacation"}, scanner.autoReasons())
+
+	scanner, _ = newTestScanner(t, "Auto-Submitted: no\nPrecedence: first-class\nSubject: hello\n\nbody\n", nil)
+	require.Nil(t, scanner.ReadHeader())
+	require.Equal(t, []string{}, scanner.autoReasons())
+
+	scanner, _ = newTestScanner(t, "X-Autoreply: yes\n\nbody\n", nil)
+	require.Nil(t, scanner.ReadHeader())
+	require.Equal(t, []string{"vacation"}, scanner.autoReasons())
+}
+
+func TestAutoSkipLookup(t *testing.T) {
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/jane@example.com/": scanResponse("family", "family"),
+	}}
+	message := "From: jane@example.com\nAuto-Submitted: auto-replied\n\nbody\n"
+
+	scanner, output := newTestScanner(t, message, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "auto-submitted=auto-replied", outputHeader(output, "X-FilterBooks-Auto"))
+	require.Equal(t, "family", outputHeader(output, "X-FilterBook"))
+
+	initTestConfig(t)
+	ViperSet("auto.skip_lookup", true)
+	ViperSet("rules", []map[string]any{{"name": "tag-all", "match": map[string]any{"min_size": 1}, "action": "tag", "tag": "all"}})
+	defer ViperSet("auto", nil)
+	defer ViperSet("rules", nil)
+	client.requests = nil
+	scanner, output = newTestScanner(t, message, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "auto-submitted=auto-replied", outputHeader(output, "X-FilterBooks-Auto"))
+	require.Equal(t, "", outputHeader(output, "X-FilterBook"))
+	require.Empty(t, client.requests)
+
+	// the rule headers are written without the lookup
+	require.Equal(t, "all", outputHeader(output, "X-FilterBooks-Tags"))
+	require.Equal(t, "tag-all", outputHeader(output, "X-FilterBooks-Rules"))
+}
@@ -0,0 +1,71 @@
+// newsletter and bulk mail classification
+package scanner
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+)
+
+// headers identifying email service providers used for bulk sending
+var ESP_HEADERS = map[string]string{
+	"x-sg-eid":         "sendgrid",
+	"x-mailgun-sid":    "mailgun",
+	"x-ses-outgoing":   "amazon-ses",
+	"x-mc-user":        "mailchimp",
+	"x-mandrill-user":  "mandrill",
+	"x-pm-message-id":  "postmark",
+	"x-mj-mid":         "mailjet",
+	"x-sib-id":         "brevo",
+	"x-sfmc-stack":     "salesforce",
+	"x-hs-cid":         "hubspot",
+	"x-csa-complaints": "csa",
+}
+
+// return the signals marking the message as bulk mail
+func (s *Scanner) bulkReasons() []string {
+	reasons := []string{}
+	esps := []string{}
+	for _, field := range s.fields {
+		if esp, ok := ESP_HEADERS[strings.ToLower(field.Name)]; ok && !slices.Contains(esps, esp) {
+			esps = append(esps, esp)
+		}
+	}
+	slices.Sort(esps)
+	for _, esp := range esps {
+		reasons = append(reasons, "esp="+esp)
+	}
+	if s.HeaderValue("list-unsubscribe") != "" {
+		reasons = append(reasons, "unsubscribe")
+		if strings.Contains(strings.ToLower(s.HeaderValue("list-unsubscribe-post")), "list-unsubscribe=one-click") {
+			reasons = append(reasons, "one-click")
+		}
+	}
+	if value := strings.ToLower(strings.TrimSpace(s.HeaderValue("precedence"))); value == "bulk" || value == "list" {
+		reasons = append(reasons, "precedence="+value)
+	}
+	if s.HeaderValue("feedback-id") != "" {
+		reasons = append(reasons, "feedback-id")
+	}
+	return reasons
+}
+
+// report bulk mail, filing senders in no book into the configured default book
+func (s *Scanner) checkBulk() {
+	s.Bulk = s.bulkReasons()
+	if len(s.Bulk) == 0 {
+		return
+	}
+	if s.verbose {
+		log.Printf("bulk: %s\n", strings.Join(s.Bulk, " "))
+	}
+	s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Bulk: %s", strings.Join(s.Bulk, " ")))
+	book := ViperGetString("bulk.default_book")
+	if book != "" && !s.Whitelisted && s.Book == "" {
+		s.Book = book
+		if !slices.Contains(s.Books, book) {
+			s.Books = append(s.Books, book)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestBulk(t *testing.T) {
+	initTestConfig(t)
+	ViperSet("bulk.default_book", "newsletters")
+	defer ViperSet("bulk", nil)
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/news@shop.example/": scanResponse(""),
+		"/filterctl/scan/owner@example.org/jane@example.com/":  scanResponse("family", "family"),
+	}}
+
+	message := "From: news@shop.example\nX-SG-EID: abc\nX-Mailgun-Sid: def\n" +
+		"List-Unsubscribe: <https://shop.example/u/1>\nList-Unsubscribe-Post: List-Unsubscribe=One-Click\n" +
+		"Precedence: bulk\n\nbody\n"
+	scanner, output := newTestScanner(t, message, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "esp=mailgun esp=sendgrid unsubscribe one-click precedence=bulk", outputHeader(output, "X-FilterBooks-Bulk"))
+	require.Equal(t, "newsletters", outputHeader(output, "X-FilterBook"))
+	require.Equal(t, "", outputHeader(output, "X-Whitelisted"))
+
+	// a sender with a book keeps it
+	scanner, output = newTestScanner(t, "From: jane@example.com\nList-Unsubscribe: <mailto:u@example.com>\n\nbody\n", client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "unsubscribe", outputHeader(output, "X-FilterBooks-Bulk"))
+	require.Equal(t, "family", outputHeader(output, "X-FilterBook"))
+
+	scanner, output = newTestScanner(t, "From: news@shop.example\n\nbody\n", client)
+	require.Nil(t, scanner.Scan())
+	require.Empty(t, scanner.Bulk)
+	require.Equal(t, "", outputHeader(output, "X-FilterBook"))
+}
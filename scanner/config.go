@@ -0,0 +1,31 @@
+// structured config sections
+package scanner
+
+import (
+	"github.com/spf13/viper"
+)
+
+// decode the config section at key into value using mapstructure tags
+func viperUnmarshal(key string, value any) error {
+	err := viper.UnmarshalKey(ViperKey(key), value)
+	if err != nil {
+		return Fatalf("failed decoding config '%s': %v", key, err)
+	}
+	return nil
+}
+
+// decode the YAML file at filename into value using mapstructure tags
+func readConfigFile(filename string, value any) error {
+	v := viper.New()
+	v.SetConfigFile(Expand(filename))
+	v.SetConfigType("yaml")
+	err := v.ReadInConfig()
+	if err != nil {
+		return Fatalf("failed reading '%s': %v", filename, err)
+	}
+	err = v.Unmarshal(value)
+	if err != nil {
+		return Fatalf("failed decoding '%s': %v", filename, err)
+	}
+	return nil
+}
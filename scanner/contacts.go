@@ -0,0 +1,103 @@
+// local index of address book contacts
+package scanner
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+type Contact struct {
+	Book    string
+	Address string
+	Name    string
+}
+
+// ContactIndex holds the book entries listed in a CSV file of lines in the form:
+//
+//	book,address,display name
+type ContactIndex struct {
+	Contacts  []*Contact
+	names     map[string][]*Contact
+	addresses map[string][]*Contact
+}
+
+func NewContactIndex() *ContactIndex {
+	return &ContactIndex{
+		Contacts:  []*Contact{},
+		names:     make(map[string][]*Contact),
+		addresses: make(map[string][]*Contact),
+	}
+}
+
+// LoadContacts reads the contacts file named in the config, returning an empty index if none is set
+func LoadContacts() (*ContactIndex, error) {
+	index := NewContactIndex()
+	filename := ViperGetString("contacts.file")
+	if filename == "" {
+		return index, nil
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, Fatal(err)
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, Fatalf("%s: %v", filename, err)
+		}
+		if len(record) < 2 {
+			return nil, Fatalf("%s: expected book,address[,name]: %v", filename, record)
+		}
+		contact := Contact{Book: strings.TrimSpace(record[0]), Address: strings.ToLower(strings.TrimSpace(record[1]))}
+		if len(record) > 2 {
+			contact.Name = strings.TrimSpace(record[2])
+		}
+		index.Add(&contact)
+	}
+	return index, nil
+}
+
+func (c *ContactIndex) Add(contact *Contact) {
+	c.Contacts = append(c.Contacts, contact)
+	c.addresses[contact.Address] = append(c.addresses[contact.Address], contact)
+	name := normalizeName(contact.Name)
+	if name != "" {
+		c.names[name] = append(c.names[name], contact)
+	}
+}
+
+func (c *ContactIndex) ByAddress(address string) []*Contact {
+	return c.addresses[strings.ToLower(address)]
+}
+
+func (c *ContactIndex) ByName(name string) []*Contact {
+	return c.names[normalizeName(name)]
+}
+
+// reduce a display name to lower case words, reordering "Last, First" as "first last"
+func normalizeName(name string) string {
+	if last, first, found := strings.Cut(name, ","); found && !strings.Contains(first, ",") {
+		name = first + " " + last
+	}
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '@' || r == '.' || r == '-' || r == '_' || r == '+')
+	})
+	for i, word := range words {
+		words[i] = strings.Trim(word, ".-_")
+	}
+	normalized := strings.Join(strings.Fields(strings.Join(words, " ")), " ")
+	if len([]rune(normalized)) < 3 {
+		return ""
+	}
+	return normalized
+}
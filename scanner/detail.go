@@ -0,0 +1,104 @@
+// books named by sub-addressed recipients
+package scanner
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+)
+
+const DEFAULT_DETAIL_SEPARATOR = "+"
+
+type DetailConfig struct {
+	Books     []string `mapstructure:"books"`
+	Add       bool     `mapstructure:"add"`
+	Separator string   `mapstructure:"separator"`
+}
+
+func LoadDetailConfig() (*DetailConfig, error) {
+	var config DetailConfig
+	err := viperUnmarshal("detail", &config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Separator == "" {
+		config.Separator = DEFAULT_DETAIL_SEPARATOR
+	}
+	for i, book := range config.Books {
+		config.Books[i] = strings.ToLower(book)
+	}
+	return &config, nil
+}
+
+// return the detail of a sub-address of the owner's address, or an empty string
+func (s *Scanner) addressDetail(address string) string {
+	local, domain, found := strings.Cut(strings.ToLower(address), "@")
+	if !found || domain != addressDomain(s.Address) {
+		return ""
+	}
+	user, detail, found := strings.Cut(local, s.detailConfig.Separator)
+	if !found || user != strings.ToLower(s.User) {
+		return ""
+	}
+	return detail
+}
+
+// return the recipient detail from the envelope recipient, or without one the To and Cc addresses;
+// the headers are sender controlled and only consulted when the envelope recipient is unknown
+func (s *Scanner) recipientDetail() string {
+	if recipient := strings.Trim(strings.TrimSpace(s.Recipient), "<>"); recipient != "" {
+		return s.addressDetail(recipient)
+	}
+	for _, address := range append(slices.Clone(s.ToList), s.CcList...) {
+		if detail := s.addressDetail(address); detail != "" {
+			return detail
+		}
+	}
+	return ""
+}
+
+// return true if the sender failed any of the authenticity checks
+func (s *Scanner) suspect() bool {
+	return s.Unauthenticated || s.Spoofed || s.Lookalike != "" || s.Impersonation != nil
+}
+
+// file a sender in no book into the allow-listed book named by the recipient detail, adding it to the book if so configured
+func (s *Scanner) checkDetail() {
+	s.Detail = s.recipientDetail()
+	if s.Detail == "" {
+		return
+	}
+	if !slices.Contains(s.detailConfig.Books, s.Detail) {
+		s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Detail: %s", s.Detail))
+		return
+	}
+	s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Detail: %s book=%s", s.Detail, s.Detail))
+	if s.forced || s.Override != nil || s.Whitelisted || s.Book != "" || s.suspect() {
+		return
+	}
+	s.Book = s.Detail
+	if !slices.Contains(s.Books, s.Detail) {
+		s.Books = append(s.Books, s.Detail)
+	}
+	if !s.detailConfig.Add || s.From == "" {
+		return
+	}
+	learner, err := LoadLearner()
+	if err != nil {
+		Warning("detail: %v", err)
+		return
+	}
+	if learner.dryRun {
+		log.Printf("detail: would add %s to %s\n", s.From, s.Detail)
+		return
+	}
+	err = s.addAddress(learner, s.Detail, s.From, s.FromName)
+	if err != nil {
+		Warning("detail: %v", err)
+		return
+	}
+	if s.verbose {
+		log.Printf("detail: added %s to %s\n", s.From, s.Detail)
+	}
+}
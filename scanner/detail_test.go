@@ -0,0 +1,57 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestAddressDetail(t *testing.T) {
+	scanner, _ := newTestScanner(t, "\n", nil)
+	require.Nil(t, scanner.setAddress())
+	require.Equal(t, "shopping", scanner.addressDetail("Owner+Shopping@example.org"))
+	require.Equal(t, "", scanner.addressDetail("owner@example.org"))
+	require.Equal(t, "", scanner.addressDetail("other+shopping@example.org"))
+	require.Equal(t, "", scanner.addressDetail("owner+shopping@example.net"))
+}
+
+func TestDetail(t *testing.T) {
+	initTestConfig(t)
+	ViperSet("detail", map[string]any{"books": []string{"Shopping"}, "add": true})
+	defer ViperSet("detail", nil)
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/orders@shop.example/": scanResponse(""),
+		"/filterctl/scan/owner@example.org/jane@example.com/":    scanResponse("family", "family"),
+		"/filterctl/address/": Response{Success: true},
+	}}
+
+	scanner, output := newTestScanner(t, "From: Shop <orders@shop.example>\nTo: owner+shopping@example.org\n\nbody\n", client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "shopping book=shopping", outputHeader(output, "X-FilterBooks-Detail"))
+	require.Equal(t, "shopping", outputHeader(output, "X-FilterBook"))
+	require.Contains(t, client.requests, "POST /filterctl/address/")
+
+	// a detail that is not allow-listed is only reported
+	client.requests = nil
+	scanner, output = newTestScanner(t, "From: orders@shop.example\nTo: owner@example.org\n\nbody\n", client)
+	scanner.Recipient = "owner+random@example.org"
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "random", outputHeader(output, "X-FilterBooks-Detail"))
+	require.Equal(t, "", outputHeader(output, "X-FilterBook"))
+	require.NotContains(t, client.requests, "POST /filterctl/address/")
+
+	// the To header is ignored when the envelope recipient is known
+	client.requests = nil
+	scanner, output = newTestScanner(t, "From: orders@shop.example\nTo: owner+shopping@example.org\n\nbody\n", client)
+	scanner.Recipient = "owner@example.org"
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "", outputHeader(output, "X-FilterBooks-Detail"))
+	require.Equal(t, "", outputHeader(output, "X-FilterBook"))
+	require.NotContains(t, client.requests, "POST /filterctl/address/")
+
+	// a sender with a book keeps it
+	client.requests = nil
+	scanner, output = newTestScanner(t, "From: jane@example.com\nTo: owner+shopping@example.org\n\nbody\n", client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "family", outputHeader(output, "X-FilterBook"))
+	require.NotContains(t, client.requests, "POST /filterctl/address/")
+}
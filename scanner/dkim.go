@@ -0,0 +1,363 @@
+// DKIM signature verification
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signatures beyond this count are not verified
+const MAX_DKIM_SIGNATURES = 5
+
+var DKIM_WSP = regexp.MustCompile(`[ \t]+`)
+var DKIM_SIGNATURE_B_TAG = regexp.MustCompile(`((?:^|[;:])\s*b\s*=)[^;]*`)
+
+// KeyResolver returns the TXT records published at a DNS name
+type KeyResolver interface {
+	LookupTXT(name string) ([]string, error)
+}
+
+type DNSResolver struct{}
+
+func (r *DNSResolver) LookupTXT(name string) ([]string, error) {
+	return net.LookupTXT(name)
+}
+
+// FileResolver answers TXT lookups from a file of lines in the form:
+//
+//	selector._domainkey.example.com  v=DKIM1; k=rsa; p=MIIBIjANBgkq...
+type FileResolver struct {
+	records map[string][]string
+}
+
+func NewFileResolver(filename string) (*FileResolver, error) {
+	file, err := os.Open(Expand(filename))
+	if err != nil {
+		return nil, Fatal(err)
+	}
+	defer file.Close()
+	r := FileResolver{records: make(map[string][]string)}
+	lines := bufio.NewScanner(file)
+	for lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, found := strings.Cut(line, " ")
+		if !found {
+			name, value, found = strings.Cut(line, "\t")
+		}
+		if !found {
+			return nil, Fatalf("%s: invalid key record: %s", filename, line)
+		}
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		r.records[name] = append(r.records[name], strings.TrimSpace(value))
+	}
+	err = lines.Err()
+	if err != nil {
+		return nil, Fatal(err)
+	}
+	return &r, nil
+}
+
+func (r *FileResolver) LookupTXT(name string) ([]string, error) {
+	records, ok := r.records[strings.ToLower(strings.TrimSuffix(name, "."))]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+type DKIMConfig struct {
+	Verify bool   `mapstructure:"verify"`
+	Keys   string `mapstructure:"keys"`
+}
+
+func LoadKeyResolver() (KeyResolver, error) {
+	var config DKIMConfig
+	err := viperUnmarshal("dkim", &config)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case !config.Verify:
+		return nil, nil
+	case config.Keys != "":
+		return NewFileResolver(config.Keys)
+	}
+	return &DNSResolver{}, nil
+}
+
+type DKIMResult struct {
+	Domain   string
+	Selector string
+	Result   string
+	Reason   string
+}
+
+func (r *DKIMResult) String() string {
+	ret := fmt.Sprintf("%s d=%s s=%s", r.Result, r.Domain, r.Selector)
+	if r.Reason != "" {
+		ret += fmt.Sprintf(" (%s)", r.Reason)
+	}
+	return ret
+}
+
+func parseTagList(value string) map[string]string {
+	tags := make(map[string]string)
+	for _, spec := range strings.Split(value, ";") {
+		name, value, found := strings.Cut(spec, "=")
+		if found {
+			tags[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	return tags
+}
+
+func removeWhitespace(value string) string {
+	return strings.Join(strings.Fields(value), "")
+}
+
+// convert bare LF line endings to CRLF
+func crlfBody(body []byte) []byte {
+	return bytes.ReplaceAll(bytes.ReplaceAll(body, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+}
+
+func canonicalBody(body []byte, relaxed bool) []byte {
+	lines := strings.Split(string(crlfBody(body)), "\r\n")
+	if relaxed {
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(DKIM_WSP.ReplaceAllString(line, " "), " ")
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		if relaxed {
+			return []byte{}
+		}
+		return []byte("\r\n")
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+func canonicalHeader(field *Field, relaxed bool) string {
+	if !relaxed {
+		return field.Raw + "\r\n"
+	}
+	name, value, _ := strings.Cut(field.Raw, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.TrimSpace(DKIM_WSP.ReplaceAllString(value, " "))
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + value + "\r\n"
+}
+
+// verify one DKIM-Signature field against the scanned message
+func (s *Scanner) verifySignature(signature *Field) *DKIMResult {
+	tags := parseTagList(signature.Value)
+	result := DKIMResult{Domain: strings.ToLower(tags["d"]), Selector: tags["s"], Result: "permerror"}
+	for _, tag := range []string{"v", "a", "b", "bh", "d", "h", "s"} {
+		if tags[tag] == "" {
+			result.Reason = "missing tag " + tag
+			return &result
+		}
+	}
+	if tags["v"] != "1" {
+		result.Reason = "unsupported version"
+		return &result
+	}
+	// RFC 6376 section 5.4: the From field must be signed
+	signed := strings.Split(strings.ToLower(removeWhitespace(tags["h"])), ":")
+	if !slices.Contains(signed, "from") {
+		result.Reason = "from not signed"
+		return &result
+	}
+	// RFC 6376 section 3.5: the identity must be in the signing domain
+	identityDomain := result.Domain
+	if i := tags["i"]; i != "" {
+		_, identityDomain, _ = strings.Cut(strings.ToLower(i), "@")
+		if identityDomain != result.Domain && !strings.HasSuffix(identityDomain, "."+result.Domain) {
+			result.Reason = "identity not in signing domain"
+			return &result
+		}
+	}
+	if x := tags["x"]; x != "" {
+		expires, err := strconv.ParseInt(x, 10, 64)
+		if err == nil && time.Now().Unix() > expires {
+			result.Result = "fail"
+			result.Reason = "signature expired"
+			return &result
+		}
+	}
+	headerCanon, bodyCanon, _ := strings.Cut(tags["c"], "/")
+	relaxedHeader := headerCanon == "relaxed"
+	relaxedBody := bodyCanon == "relaxed"
+
+	body := canonicalBody(s.body, relaxedBody)
+	if l := tags["l"]; l != "" {
+		length, err := strconv.Atoi(l)
+		if err != nil || length > len(body) {
+			result.Reason = "invalid body length"
+			return &result
+		}
+		body = body[:length]
+	}
+	bodyHash := sha256.Sum256(body)
+	if base64.StdEncoding.EncodeToString(bodyHash[:]) != removeWhitespace(tags["bh"]) {
+		result.Result = "fail"
+		result.Reason = "body hash mismatch"
+		return &result
+	}
+
+	// select signed fields from the bottom of the header up
+	used := make(map[*Field]bool)
+	hash := sha256.New()
+	for _, name := range strings.Split(tags["h"], ":") {
+		name = strings.TrimSpace(name)
+		for i := len(s.fields) - 1; i >= 0; i-- {
+			field := s.fields[i]
+			if !used[field] && strings.EqualFold(field.Name, name) {
+				used[field] = true
+				hash.Write([]byte(canonicalHeader(field, relaxedHeader)))
+				break
+			}
+		}
+	}
+	unsigned := Field{Name: signature.Name, Raw: DKIM_SIGNATURE_B_TAG.ReplaceAllString(signature.Raw, "$1")}
+	hash.Write([]byte(strings.TrimSuffix(canonicalHeader(&unsigned, relaxedHeader), "\r\n")))
+	digest := hash.Sum(nil)
+
+	sig, err := base64.StdEncoding.DecodeString(removeWhitespace(tags["b"]))
+	if err != nil {
+		result.Reason = "invalid signature encoding"
+		return &result
+	}
+	records, err := s.resolver.LookupTXT(tags["s"] + "._domainkey." + tags["d"])
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			result.Reason = "no key"
+			return &result
+		}
+		result.Result = "temperror"
+		result.Reason = "key lookup failed"
+		return &result
+	}
+	key := parseTagList(strings.Join(records, ""))
+	if v := key["v"]; v != "" && v != "DKIM1" {
+		result.Reason = "invalid key version"
+		return &result
+	}
+	keyType := key["k"]
+	if keyType == "" {
+		keyType = "rsa"
+	}
+	if algorithm, _, _ := strings.Cut(tags["a"], "-"); keyType != algorithm {
+		result.Reason = "key type mismatch"
+		return &result
+	}
+	if h := key["h"]; h != "" && !slices.Contains(strings.Split(removeWhitespace(h), ":"), "sha256") {
+		result.Reason = "hash algorithm not allowed by key"
+		return &result
+	}
+	if flags := strings.Split(removeWhitespace(key["t"]), ":"); slices.Contains(flags, "s") && identityDomain != result.Domain {
+		result.Reason = "subdomain identity not allowed by key"
+		return &result
+	}
+	keyData, err := base64.StdEncoding.DecodeString(removeWhitespace(key["p"]))
+	if err != nil || len(keyData) == 0 {
+		result.Reason = "invalid or revoked key"
+		return &result
+	}
+	switch tags["a"] {
+	case "rsa-sha256":
+		publicKey, err := parseRSAKey(keyData)
+		if err != nil {
+			result.Reason = "invalid key"
+			return &result
+		}
+		err = rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest, sig)
+		if err != nil {
+			result.Result = "fail"
+			result.Reason = "signature mismatch"
+			return &result
+		}
+	case "ed25519-sha256":
+		if len(keyData) != ed25519.PublicKeySize {
+			result.Reason = "invalid key"
+			return &result
+		}
+		if !ed25519.Verify(ed25519.PublicKey(keyData), digest, sig) {
+			result.Result = "fail"
+			result.Reason = "signature mismatch"
+			return &result
+		}
+	default:
+		result.Reason = "unsupported algorithm " + tags["a"]
+		return &result
+	}
+	result.Result = "pass"
+	return &result
+}
+
+func parseRSAKey(data []byte) (*rsa.PublicKey, error) {
+	key, err := x509.ParsePKIXPublicKey(data)
+	if err != nil {
+		return x509.ParsePKCS1PublicKey(data)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// verify the message's DKIM signatures, merging a pass aligned with the From domain into the dkim auth result
+func (s *Scanner) verifyDKIM() error {
+	if s.resolver == nil {
+		return nil
+	}
+	signatures := []*Field{}
+	for _, field := range s.fields {
+		if strings.EqualFold(field.Name, "dkim-signature") && len(signatures) < MAX_DKIM_SIGNATURES {
+			signatures = append(signatures, field)
+		}
+	}
+	if len(signatures) == 0 {
+		s.AddHeaderLine("X-FilterBooks-DKIM: none")
+		return nil
+	}
+	err := s.ReadBody()
+	if err != nil {
+		return Fatal(err)
+	}
+	fromDomain := addressDomain(s.From)
+	summary := []string{}
+	for _, signature := range signatures {
+		result := s.verifySignature(signature)
+		if s.verbose {
+			log.Printf("DKIM: %s\n", result)
+		}
+		s.DKIM = append(s.DKIM, result)
+		summary = append(summary, result.String())
+		if domainAligned(result.Domain, fromDomain) && s.Auth["dkim"] != "pass" {
+			s.Auth["dkim"] = result.Result
+		}
+	}
+	s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-DKIM: %s", strings.Join(summary, "; ")))
+	return nil
+}
@@ -0,0 +1,199 @@
+package scanner
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const dkimMessage = "From: Sender <sender@example.com>\r\n" +
+	"To: owner@example.org\r\n" +
+	"Subject: signed  \r\n" +
+	"\tmessage\r\n" +
+	"\r\n" +
+	"Hello,  world \r\n" +
+	"\r\n" +
+	"\r\n"
+
+func TestDKIMCanonicalization(t *testing.T) {
+	// RFC 6376 section 3.4.6
+	require.Equal(t, "a:X\r\n", canonicalHeader(&Field{Raw: "A: X"}, true))
+	require.Equal(t, "b:Y Z\r\n", canonicalHeader(&Field{Raw: "B : Y\t\r\n\tZ  "}, true))
+	body := []byte(" C \r\nD \t E\r\n\r\n\r\n")
+	require.Equal(t, " C\r\nD E\r\n", string(canonicalBody(body, true)))
+	require.Equal(t, " C \r\nD \t E\r\n", string(canonicalBody(body, false)))
+	require.Equal(t, "\r\n", string(canonicalBody([]byte{}, false)))
+	require.Equal(t, "", string(canonicalBody([]byte("\r\n"), true)))
+}
+
+// prepend a DKIM-Signature to message using the scanner's canonicalization
+func dkimSign(t *testing.T, message, algorithm, canon, selector string, signer crypto.Signer) string {
+	return dkimSignTags(t, message, algorithm, canon, selector, "", "from:subject:to:to", signer)
+}
+
+// prepend a DKIM-Signature with extra tags and the signed header list h
+func dkimSignTags(t *testing.T, message, algorithm, canon, selector, extra, h string, signer crypto.Signer) string {
+	s, _ := newTestScanner(t, message, nil)
+	require.Nil(t, s.ReadHeader())
+	require.Nil(t, s.ReadBody())
+	headerCanon, bodyCanon, _ := strings.Cut(canon, "/")
+	bodyHash := sha256.Sum256(canonicalBody(s.body, bodyCanon == "relaxed"))
+	field := Field{Name: "DKIM-Signature", Raw: fmt.Sprintf("DKIM-Signature: v=1; a=%s; c=%s; d=example.com; s=%s;%s\r\n\th=%s; bh=%s;\r\n\tb=",
+		algorithm, canon, selector, extra, h, base64.StdEncoding.EncodeToString(bodyHash[:]))}
+	hash := sha256.New()
+	used := make(map[string]bool)
+	for _, name := range strings.Split(h, ":") {
+		if used[name] {
+			continue
+		}
+		used[name] = true
+		hash.Write([]byte(canonicalHeader(s.fields[map[string]int{"from": 0, "to": 1, "subject": 2}[name]], headerCanon == "relaxed")))
+	}
+	hash.Write([]byte(strings.TrimSuffix(canonicalHeader(&field, headerCanon == "relaxed"), "\r\n")))
+	var opts crypto.SignerOpts = crypto.SHA256
+	if algorithm == "ed25519-sha256" {
+		opts = crypto.Hash(0)
+	}
+	sig, err := signer.Sign(rand.Reader, hash.Sum(nil), opts)
+	require.Nil(t, err)
+	return field.Raw + base64.StdEncoding.EncodeToString(sig) + "\r\n" + message
+}
+
+func TestDKIMVerify(t *testing.T) {
+	initTestConfig(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	rsaPublic, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.Nil(t, err)
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	rsaText := base64.StdEncoding.EncodeToString(rsaPublic)
+	keys := fmt.Sprintf("# test keys\nrsa._domainkey.example.com. v=DKIM1; k=rsa; p=%s\ned._domainkey.example.com v=DKIM1; k=ed25519; p=%s\n",
+		rsaText, base64.StdEncoding.EncodeToString(edPublic))
+	keys += fmt.Sprintf("strict._domainkey.example.com v=DKIM1; t=s; p=%s\nsha1._domainkey.example.com v=DKIM1; h=sha1; p=%s\nwrongtype._domainkey.example.com k=ed25519; p=%s\n",
+		rsaText, rsaText, rsaText)
+	require.Nil(t, os.WriteFile(keyFile, []byte(keys), 0600))
+	ViperSet("dkim", map[string]any{"verify": true, "keys": keyFile})
+	ViperSet("auth", map[string]any{"require": []string{"dkim"}})
+	defer ViperSet("dkim", nil)
+	defer ViperSet("auth", nil)
+
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/sender@example.com/": scanResponse("family", "family"),
+	}}
+	scan := func(message string) (*Scanner, string) {
+		scanner, output := newTestScanner(t, message, client)
+		require.Nil(t, scanner.Scan())
+		require.True(t, strings.HasSuffix(output.String(), "\n\nHello,  world \r\n\r\n\r\n"))
+		return scanner, outputHeader(output, "X-FilterBooks-DKIM")
+	}
+
+	for _, canon := range []string{"relaxed/relaxed", "simple/simple", "relaxed/simple"} {
+		scanner, result := scan(dkimSign(t, dkimMessage, "rsa-sha256", canon, "rsa", rsaKey))
+		require.Equal(t, "pass d=example.com s=rsa", result, canon)
+		require.Equal(t, "pass", scanner.Auth["dkim"])
+		require.False(t, scanner.Unauthenticated)
+	}
+
+	scanner, result := scan(dkimSign(t, dkimMessage, "ed25519-sha256", "relaxed/relaxed", "ed", edKey))
+	require.Equal(t, "pass d=example.com s=ed", result)
+	require.Equal(t, "family", scanner.Book)
+
+	tampered := strings.Replace(dkimSign(t, dkimMessage, "rsa-sha256", "relaxed/relaxed", "rsa", rsaKey), "Subject: signed", "Subject: forged", 1)
+	scanner, result = scan(tampered)
+	require.Equal(t, "fail d=example.com s=rsa (signature mismatch)", result)
+	require.True(t, scanner.Unauthenticated)
+
+	scanner, result = scan(dkimSign(t, dkimMessage, "rsa-sha256", "relaxed/relaxed", "missing", rsaKey))
+	require.Equal(t, "permerror d=example.com s=missing (no key)", result)
+	require.True(t, scanner.Unauthenticated)
+
+	_, result = scan(dkimMessage)
+	require.Equal(t, "none", result)
+
+	// a valid signature not covering From cannot vouch for the From address
+	scanner, result = scan(dkimSignTags(t, dkimMessage, "rsa-sha256", "relaxed/relaxed", "rsa", "", "subject:to", rsaKey))
+	require.Equal(t, "permerror d=example.com s=rsa (from not signed)", result)
+	require.True(t, scanner.Unauthenticated)
+
+	_, result = scan(dkimSignTags(t, dkimMessage, "rsa-sha256", "relaxed/relaxed", "rsa", " i=@evil.example;", "from:subject:to", rsaKey))
+	require.Equal(t, "permerror d=example.com s=rsa (identity not in signing domain)", result)
+
+	_, result = scan(dkimSignTags(t, dkimMessage, "rsa-sha256", "relaxed/relaxed", "rsa", " i=news@mail.example.com;", "from:subject:to", rsaKey))
+	require.Equal(t, "pass d=example.com s=rsa", result)
+
+	_, result = scan(dkimSignTags(t, dkimMessage, "rsa-sha256", "relaxed/relaxed", "strict", " i=news@mail.example.com;", "from:subject:to", rsaKey))
+	require.Equal(t, "permerror d=example.com s=strict (subdomain identity not allowed by key)", result)
+
+	_, result = scan(dkimSignTags(t, dkimMessage, "rsa-sha256", "relaxed/relaxed", "strict", " i=@example.com;", "from:subject:to", rsaKey))
+	require.Equal(t, "pass d=example.com s=strict", result)
+
+	_, result = scan(dkimSign(t, dkimMessage, "rsa-sha256", "relaxed/relaxed", "sha1", rsaKey))
+	require.Equal(t, "permerror d=example.com s=sha1 (hash algorithm not allowed by key)", result)
+
+	_, result = scan(dkimSign(t, dkimMessage, "rsa-sha256", "relaxed/relaxed", "wrongtype", rsaKey))
+	require.Equal(t, "permerror d=example.com s=wrongtype (key type mismatch)", result)
+}
+
+// the signed example message of RFC 8463 appendix A, signed independently of this verifier
+const rfc8463Message = "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+	" d=football.example.com; i=@football.example.com;\r\n" +
+	" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n" +
+	" subject : date : message-id : from : subject : date;\r\n" +
+	" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+	" b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus\r\n" +
+	" Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==\r\n" +
+	"DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed;\r\n" +
+	" d=football.example.com; i=@football.example.com;\r\n" +
+	" q=dns/txt; s=test; t=1528637909; h=from : to : subject :\r\n" +
+	" date : message-id : from : subject : date;\r\n" +
+	" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+	" b=F45dVWDfMbQDGHJFlXUNB2HKfbCeLRyhDXgFpEL8GwpsRe0IeIixNTe3\r\n" +
+	" DhCVlUrSjV4BwcVcOF6+FF3Zo9Rpo1tFOeS9mPYQTnGdaSGsgeefOsk2Jz\r\n" +
+	" dA+L10TeYt9BgDfQNZtKdN1WO//KgIqXP7OdEFE4LjFYNcUxZQ4FADY+8=\r\n" +
+	"From: Joe SixPack <joe@football.example.com>\r\n" +
+	"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+	"Subject: Is dinner ready?\r\n" +
+	"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+	"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+	"\r\n" +
+	"Hi.\r\n" +
+	"\r\n" +
+	"We lost the game.  Are you hungry yet?\r\n" +
+	"\r\n" +
+	"Joe.\r\n"
+
+const rfc8463Keys = "brisbane._domainkey.football.example.com v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=\n" +
+	"test._domainkey.football.example.com v=DKIM1; k=rsa; p=MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQDkHlOQoBTzWRiGs5V6NpP3idY6Wk08a5qhdR6wy5bdOKb2jLQiY/J16JYi0Qvx/byYzCNb3W91y3FutACDfzwQ/BC/e/8uBsCR+yz1Lxj+PL6lHvqMKrM3rG4hstT5QjvHO9PzoxZyVYLzBfO2EeC3Ip3G+2kryOTIKT+l/K4w3QIDAQAB\n"
+
+func TestDKIMRFC8463(t *testing.T) {
+	initTestConfig(t)
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	require.Nil(t, os.WriteFile(keyFile, []byte(rfc8463Keys), 0600))
+	ViperSet("dkim", map[string]any{"verify": true, "keys": keyFile})
+	defer ViperSet("dkim", nil)
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/joe@football.example.com/": scanResponse(""),
+	}}
+
+	scanner, output := newTestScanner(t, rfc8463Message, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "pass d=football.example.com s=brisbane; pass d=football.example.com s=test", outputHeader(output, "X-FilterBooks-DKIM"))
+	require.Equal(t, "pass", scanner.Auth["dkim"])
+
+	scanner, output = newTestScanner(t, strings.Replace(rfc8463Message, "We lost", "We won", 1), client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "fail d=football.example.com s=brisbane (body hash mismatch); fail d=football.example.com s=test (body hash mismatch)", outputHeader(output, "X-FilterBooks-DKIM"))
+}
@@ -0,0 +1,166 @@
+// delivery status notification parsing
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// recipients beyond this count are not looked up
+const MAX_DSN_RECIPIENTS = 5
+
+type BounceRecipient struct {
+	Address string
+	Action  string
+	Status  string
+	Book    string
+}
+
+func (r *BounceRecipient) String() string {
+	ret := fmt.Sprintf("%s action=%s status=%s", r.Address, r.Action, r.Status)
+	if r.Book != "" {
+		ret += " book=" + r.Book
+	}
+	return ret
+}
+
+// Bounce holds the failed recipients of a delivery status notification and the headers of the original message
+type Bounce struct {
+	Recipients []*BounceRecipient
+	MessageId  string
+	Subject    string
+	To         string
+}
+
+// parse a header block of delivery-status fields, which may be folded
+func parseStatusFields(block string) textproto.MIMEHeader {
+	reader := textproto.NewReader(bufio.NewReader(strings.NewReader(strings.TrimSpace(block) + "\r\n\r\n")))
+	fields, err := reader.ReadMIMEHeader()
+	if err != nil && len(fields) == 0 {
+		return textproto.MIMEHeader{}
+	}
+	return fields
+}
+
+// return the address of a recipient field in the form "rfc822; user@example.com"
+func statusAddress(value string) string {
+	addrType, address, found := strings.Cut(value, ";")
+	if !found || !strings.EqualFold(strings.TrimSpace(addrType), "rfc822") {
+		return ""
+	}
+	address = strings.ToLower(strings.Trim(strings.TrimSpace(address), "<>"))
+	if !VALID_EMAIL_ADDRESS.MatchString(address) {
+		return ""
+	}
+	return address
+}
+
+// parse the per-recipient blocks of a message/delivery-status part, returning those not delivered
+func parseDeliveryStatus(data []byte) []*BounceRecipient {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	blocks := strings.Split(text, "\n\n")
+	recipients := []*BounceRecipient{}
+	// the first block holds the per-message fields
+	for _, block := range blocks[1:] {
+		fields := parseStatusFields(block)
+		address := statusAddress(fields.Get("Original-Recipient"))
+		if address == "" {
+			address = statusAddress(fields.Get("Final-Recipient"))
+		}
+		action := strings.ToLower(strings.TrimSpace(fields.Get("Action")))
+		if address == "" || action == "delivered" || action == "relayed" || action == "expanded" {
+			continue
+		}
+		status, _, _ := strings.Cut(strings.TrimSpace(fields.Get("Status")), " ")
+		recipients = append(recipients, &BounceRecipient{Address: address, Action: action, Status: status})
+	}
+	return recipients
+}
+
+// parse a multipart/report delivery status notification from the message body, returning nil if it is malformed
+func (s *Scanner) parseDSN() (*Bounce, error) {
+	mediaType, params, err := mime.ParseMediaType(s.HeaderValue("content-type"))
+	if err != nil || mediaType != "multipart/report" || !strings.EqualFold(params["report-type"], "delivery-status") {
+		return nil, nil
+	}
+	err = s.ReadBody()
+	if err != nil {
+		return nil, Fatal(err)
+	}
+	bounce := Bounce{Recipients: []*BounceRecipient{}}
+	reader := multipart.NewReader(bytes.NewReader(s.body), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// a malformed report is passed through unchanged
+			Warning("delivery status notification: %v", err)
+			return nil, nil
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		data, err := io.ReadAll(part)
+		if err != nil {
+			Warning("delivery status notification: %v", err)
+			return nil, nil
+		}
+		switch partType {
+		case "message/delivery-status", "message/global-delivery-status":
+			bounce.Recipients = append(bounce.Recipients, parseDeliveryStatus(data)...)
+		case "message/rfc822", "text/rfc822-headers", "message/global-headers":
+			original, err := mail.ReadMessage(bytes.NewReader(append(data, []byte("\r\n\r\n")...)))
+			if err != nil {
+				continue
+			}
+			bounce.MessageId = s.bracketedText(original.Header.Get("Message-Id"))
+			bounce.Subject = original.Header.Get("Subject")
+			bounce.To = original.Header.Get("To")
+		}
+	}
+	return &bounce, nil
+}
+
+// look up the failed recipients of a delivery status notification and tag the bounce with their books
+func (s *Scanner) checkDSN() error {
+	bounce, err := s.parseDSN()
+	if err != nil {
+		return Fatal(err)
+	}
+	if bounce == nil {
+		return nil
+	}
+	s.Bounce = bounce
+	if s.verbose {
+		log.Printf("DSN: %d failed recipients, original message %s\n", len(bounce.Recipients), bounce.MessageId)
+	}
+	lines := []string{}
+	for i, recipient := range bounce.Recipients {
+		if i >= MAX_DSN_RECIPIENTS {
+			break
+		}
+		response, err := s.lookupAddress(recipient.Address)
+		if err != nil {
+			Warning("bounce recipient lookup: %v", err)
+		} else {
+			recipient.Book = response.Book
+		}
+		lines = append(lines, fmt.Sprintf("X-FilterBooks-Bounce: %s", recipient))
+	}
+	if bounce.MessageId != "" {
+		lines = append(lines, fmt.Sprintf("X-FilterBooks-Bounce-Message-Id: <%s>", bounce.MessageId))
+	}
+	// header lines are prepended, so add them in reverse to keep the recipient order
+	for i := len(lines) - 1; i >= 0; i-- {
+		s.AddHeaderLine(lines[i])
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+const testDSN = "From: MAILER-DAEMON@mx.example.org\n" +
+	"To: owner@example.org\n" +
+	"Subject: Undelivered Mail Returned to Sender\n" +
+	"MIME-Version: 1.0\n" +
+	"Content-Type: multipart/report; report-type=delivery-status;\n" +
+	"\tboundary=\"BOUNDARY\"\n" +
+	"\n" +
+	"--BOUNDARY\n" +
+	"Content-Type: text/plain\n" +
+	"\n" +
+	"Your message could not be delivered.\n" +
+	"\n" +
+	"--BOUNDARY\n" +
+	"Content-Type: message/delivery-status\n" +
+	"\n" +
+	"Reporting-MTA: dns; mx.example.org\n" +
+	"\n" +
+	"Final-Recipient: rfc822; jane@example.com\n" +
+	"Original-Recipient: rfc822;Jane@Example.com\n" +
+	"Action: failed\n" +
+	"Status: 5.1.1\n" +
+	"Diagnostic-Code: smtp; 550 5.1.1 user unknown\n" +
+	"\n" +
+	"Final-Recipient: rfc822; pat@corp.example\n" +
+	"Action: delayed\n" +
+	"Status: 4.4.1 (connection timed out)\n" +
+	"\n" +
+	"Final-Recipient: rfc822; ok@corp.example\n" +
+	"Action: delivered\n" +
+	"Status: 2.0.0\n" +
+	"\n" +
+	"--BOUNDARY\n" +
+	"Content-Type: text/rfc822-headers\n" +
+	"\n" +
+	"Message-Id: <original@example.org>\n" +
+	"To: jane@example.com, pat@corp.example\n" +
+	"Subject: lunch\n" +
+	"\n" +
+	"--BOUNDARY--\n"
+
+func TestParseDeliveryStatus(t *testing.T) {
+	recipients := parseDeliveryStatus([]byte("Reporting-MTA: dns; mx\n\nFinal-Recipient: rfc822; a@example.com\nAction: failed\nStatus: 5.0.0\n"))
+	require.Len(t, recipients, 1)
+	require.Equal(t, "a@example.com action=failed status=5.0.0", recipients[0].String())
+}
+
+func TestDSN(t *testing.T) {
+	initTestConfig(t)
+	ViperSet("dsn.parse", true)
+	defer ViperSet("dsn", nil)
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/jane@example.com/": scanResponse("family", "family"),
+		"/filterctl/scan/owner@example.org/pat@corp.example/": scanResponse(""),
+	}}
+	scanner, output := newTestScanner(t, testDSN, client)
+	scanner.Sender = "MAILER-DAEMON@mx.example.org"
+	require.Nil(t, scanner.Scan())
+	require.True(t, scanner.skip)
+	require.NotNil(t, scanner.Bounce)
+	require.Equal(t, "original@example.org", scanner.Bounce.MessageId)
+	require.Equal(t, "lunch", scanner.Bounce.Subject)
+	require.Len(t, scanner.Bounce.Recipients, 2)
+	require.Equal(t, "jane@example.com action=failed status=5.1.1 book=family", scanner.Bounce.Recipients[0].String())
+	require.Equal(t, "pat@corp.example action=delayed status=4.4.1", scanner.Bounce.Recipients[1].String())
+	require.Equal(t, "jane@example.com action=failed status=5.1.1 book=family", outputHeader(output, "X-FilterBooks-Bounce"))
+	require.Equal(t, "<original@example.org>", outputHeader(output, "X-FilterBooks-Bounce-Message-Id"))
+	require.Contains(t, output.String(), "--BOUNDARY--\n")
+
+	// a message that is not a report is untouched
+	scanner, output = newTestScanner(t, "From: jane@example.com\n\nbody\n", client)
+	require.Nil(t, scanner.Scan())
+	require.Nil(t, scanner.Bounce)
+	require.Equal(t, "", outputHeader(output, "X-FilterBooks-Bounce"))
+}
+
+func TestMalformedDSN(t *testing.T) {
+	initTestConfig(t)
+	ViperSet("dsn.parse", true)
+	defer ViperSet("dsn", nil)
+	truncated := strings.TrimSuffix(testDSN, "--BOUNDARY--\n")
+	client := &testClient{responses: map[string]any{}}
+	scanner, output := newTestScanner(t, truncated, client)
+	scanner.Sender = "MAILER-DAEMON@mx.example.org"
+	require.Nil(t, scanner.Scan())
+	require.Nil(t, scanner.Bounce)
+	require.Empty(t, client.requests)
+	require.Equal(t, truncated, output.String())
+}
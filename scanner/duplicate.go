@@ -0,0 +1,37 @@
+// detection of messages delivered more than once
+package scanner
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+const MESSAGE_ID_BUCKET = "message-id"
+
+// Message-Ids are forgotten after this many days unless duplicate.max_age_days is set
+const DEFAULT_MESSAGE_ID_MAX_AGE_DAYS = 7
+
+// record the time each Message-Id was first seen, reporting a message seen before
+func (s *Scanner) checkDuplicate() {
+	if !ViperGetBool("duplicate.check") || s.MessageId == "" {
+		return
+	}
+	store, err := s.openStore()
+	if err != nil {
+		Warning("duplicate check: %v", err)
+		return
+	}
+	defer s.releaseStore()
+	store.Prune(MESSAGE_ID_BUCKET, maxAge("duplicate.max_age_days", DEFAULT_MESSAGE_ID_MAX_AGE_DAYS))
+	entry := store.Get(MESSAGE_ID_BUCKET, s.MessageId)
+	if entry == nil {
+		store.Put(MESSAGE_ID_BUCKET, s.MessageId, "", time.Now())
+		return
+	}
+	s.Duplicate = true
+	if s.verbose {
+		log.Printf("duplicate Message-Id %s first seen %s\n", s.MessageId, entry.Time)
+	}
+	s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Duplicate: first-seen=%s", entry.Time.Format(time.RFC3339)))
+}
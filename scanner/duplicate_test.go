@@ -0,0 +1,38 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestDuplicate(t *testing.T) {
+	initTestConfig(t)
+	ViperSet("cache_dir", t.TempDir())
+	ViperSet("duplicate.check", true)
+	defer ViperSet("cache_dir", "")
+	defer ViperSet("duplicate", nil)
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/jane@example.com/": scanResponse("family", "family"),
+	}}
+	message := "From: jane@example.com\nMessage-Id: <dup-1@example.com>\n\nbody\n"
+
+	scanner, output := newTestScanner(t, message, client)
+	require.Nil(t, scanner.Scan())
+	require.False(t, scanner.Duplicate)
+	require.Equal(t, "", outputHeader(output, "X-FilterBooks-Duplicate"))
+
+	store, err := OpenStore("owner@example.org")
+	require.Nil(t, err)
+	firstSeen := store.Get(MESSAGE_ID_BUCKET, "dup-1@example.com").Time
+	require.Nil(t, store.Close())
+
+	scanner, output = newTestScanner(t, message, client)
+	require.Nil(t, scanner.Scan())
+	require.True(t, scanner.Duplicate)
+	require.Equal(t, "first-seen="+firstSeen.Format(time.RFC3339), outputHeader(output, "X-FilterBooks-Duplicate"))
+
+	scanner, output = newTestScanner(t, "From: jane@example.com\nMessage-Id: <other@example.com>\n\nbody\n", client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "", outputHeader(output, "X-FilterBooks-Duplicate"))
+}
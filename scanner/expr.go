@@ -0,0 +1,496 @@
+// sandboxed expression language for classification policies
+package scanner
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// longest accepted expression source
+const MAX_EXPR_LEN = 4096
+
+// An Expr is a compiled expression over message variables.  Expressions
+// have no loops, assignments or I/O; values are strings, numbers, booleans
+// and string lists.
+//
+//	or:      and { "||" and }
+//	and:     compare { "&&" compare }
+//	compare: unary [ ("==" | "!=" | "<" | "<=" | ">" | ">=" | "=~" | "in") unary ]
+//	unary:   "!" unary | primary
+//	primary: string | number | "true" | "false" | name | name "(" [ or { "," or } ] ")" | "(" or ")"
+type Expr struct {
+	Text string
+	root exprNode
+}
+
+// ExprEnv supplies variable values and header fields to an evaluation
+type ExprEnv struct {
+	Vars    map[string]any
+	Headers func(name string) []string
+}
+
+type exprNode interface {
+	eval(env *ExprEnv) (any, error)
+}
+
+type exprFunc struct {
+	args int
+	call func(env *ExprEnv, args []any) (any, error)
+}
+
+var exprFuncs = map[string]exprFunc{
+	"header": {1, func(env *ExprEnv, args []any) (any, error) {
+		values := env.Headers(exprString(args[0]))
+		if len(values) == 0 {
+			return "", nil
+		}
+		return values[0], nil
+	}},
+	"headers": {1, func(env *ExprEnv, args []any) (any, error) {
+		return env.Headers(exprString(args[0])), nil
+	}},
+	"has_header": {1, func(env *ExprEnv, args []any) (any, error) {
+		return len(env.Headers(exprString(args[0]))) > 0, nil
+	}},
+	"lower": {1, func(env *ExprEnv, args []any) (any, error) {
+		return strings.ToLower(exprString(args[0])), nil
+	}},
+	"domain": {1, func(env *ExprEnv, args []any) (any, error) {
+		_, domain, _ := strings.Cut(exprString(args[0]), "@")
+		return strings.ToLower(domain), nil
+	}},
+	"contains": {2, func(env *ExprEnv, args []any) (any, error) {
+		if list, ok := args[0].([]string); ok {
+			return slices.Contains(list, exprString(args[1])), nil
+		}
+		return strings.Contains(strings.ToLower(exprString(args[0])), strings.ToLower(exprString(args[1]))), nil
+	}},
+	"matches": {2, func(env *ExprEnv, args []any) (any, error) {
+		pattern, err := NewPattern(exprString(args[1]))
+		if err != nil {
+			return nil, err
+		}
+		return pattern.Match(exprString(args[0])), nil
+	}},
+}
+
+func CompileExpr(text string, variables []string) (*Expr, error) {
+	if len(text) > MAX_EXPR_LEN {
+		return nil, Fatalf("expression exceeds %d bytes", MAX_EXPR_LEN)
+	}
+	tokens, err := exprTokens(text)
+	if err != nil {
+		return nil, err
+	}
+	p := exprParser{tokens: tokens, variables: variables}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != "" {
+		return nil, Fatalf("unexpected '%s' at end of expression", p.peek())
+	}
+	return &Expr{Text: text, root: root}, nil
+}
+
+func (e *Expr) Eval(env *ExprEnv) (any, error) {
+	return e.root.eval(env)
+}
+
+// evaluate the expression as a condition
+func (e *Expr) Test(env *ExprEnv) (bool, error) {
+	value, err := e.Eval(env)
+	if err != nil {
+		return false, err
+	}
+	return exprTruth(value), nil
+}
+
+func (e *Expr) String() string {
+	return e.Text
+}
+
+func exprTokens(text string) ([]string, error) {
+	tokens := []string{}
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			j := i + 1
+			for j < len(runes) && runes[j] != r {
+				if runes[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(runes) {
+				return nil, Fatalf("unterminated string in expression")
+			}
+			tokens = append(tokens, string(runes[i:j+1]))
+			i = j + 1
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		default:
+			if i+1 < len(runes) {
+				op := string(runes[i : i+2])
+				if slices.Contains([]string{"&&", "||", "==", "!=", "<=", ">=", "=~"}, op) {
+					tokens = append(tokens, op)
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("!<>(),", r) {
+				return nil, Fatalf("unexpected character '%c' in expression", r)
+			}
+			tokens = append(tokens, string(r))
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+type exprParser struct {
+	tokens    []string
+	pos       int
+	variables []string
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *exprParser) expect(token string) error {
+	if p.peek() != token {
+		return Fatalf("expected '%s' but found '%s'", token, p.peek())
+	}
+	p.pos++
+	return nil
+}
+
+func (p *exprParser) or() (exprNode, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicNode{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) and() (exprNode, error) {
+	left, err := p.compare()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.next()
+		right, err := p.compare()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicNode{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) compare() (exprNode, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	op := p.peek()
+	if !slices.Contains([]string{"==", "!=", "<", "<=", ">", ">=", "=~", "in"}, op) {
+		return left, nil
+	}
+	p.next()
+	right, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	node := compareNode{op: op, left: left, right: right}
+	if op == "=~" {
+		literal, ok := right.(*literalNode)
+		if !ok {
+			return nil, Fatalf("=~ requires a string literal regular expression")
+		}
+		node.regex, err = regexp.Compile(exprString(literal.value))
+		if err != nil {
+			return nil, Fatalf("invalid regular expression: %v", err)
+		}
+	}
+	return &node, nil
+}
+
+func (p *exprParser) unary() (exprNode, error) {
+	if p.peek() == "!" {
+		p.next()
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (exprNode, error) {
+	token := p.next()
+	switch {
+	case token == "":
+		return nil, Fatalf("unexpected end of expression")
+	case token == "(":
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return node, p.expect(")")
+	case token[0] == '"' || token[0] == '\'':
+		value, err := exprUnquote(token)
+		if err != nil {
+			return nil, err
+		}
+		return &literalNode{value: value}, nil
+	case token[0] == '-' || unicode.IsDigit(rune(token[0])):
+		value, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, Fatalf("invalid number '%s'", token)
+		}
+		return &literalNode{value: value}, nil
+	case token == "true" || token == "false":
+		return &literalNode{value: token == "true"}, nil
+	case unicode.IsLetter(rune(token[0])) || token[0] == '_':
+		if p.peek() == "(" {
+			return p.call(token)
+		}
+		if !slices.Contains(p.variables, token) {
+			return nil, Fatalf("unknown variable '%s'", token)
+		}
+		return &varNode{name: token}, nil
+	}
+	return nil, Fatalf("unexpected '%s'", token)
+}
+
+func (p *exprParser) call(name string) (exprNode, error) {
+	fn, ok := exprFuncs[name]
+	if !ok {
+		return nil, Fatalf("unknown function '%s'", name)
+	}
+	p.next()
+	args := []exprNode{}
+	for p.peek() != ")" {
+		if len(args) > 0 {
+			err := p.expect(",")
+			if err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next()
+	if len(args) != fn.args {
+		return nil, Fatalf("%s() takes %d argument(s), got %d", name, fn.args, len(args))
+	}
+	if name == "matches" {
+		if literal, ok := args[1].(*literalNode); ok {
+			_, err := NewPattern(exprString(literal.value))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &callNode{name: name, fn: fn, args: args}, nil
+}
+
+func exprUnquote(token string) (string, error) {
+	if token[0] == '\'' {
+		token = `"` + strings.ReplaceAll(strings.ReplaceAll(token[1:len(token)-1], `"`, `\"`), `\'`, `'`) + `"`
+	}
+	value, err := strconv.Unquote(token)
+	if err != nil {
+		return "", Fatalf("invalid string %s", token)
+	}
+	return value, nil
+}
+
+type literalNode struct {
+	value any
+}
+
+func (n *literalNode) eval(env *ExprEnv) (any, error) {
+	return n.value, nil
+}
+
+type varNode struct {
+	name string
+}
+
+func (n *varNode) eval(env *ExprEnv) (any, error) {
+	return env.Vars[n.name], nil
+}
+
+type notNode struct {
+	operand exprNode
+}
+
+func (n *notNode) eval(env *ExprEnv) (any, error) {
+	value, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return !exprTruth(value), nil
+}
+
+type logicNode struct {
+	op    string
+	left  exprNode
+	right exprNode
+}
+
+func (n *logicNode) eval(env *ExprEnv) (any, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if exprTruth(left) == (n.op == "||") {
+		return n.op == "||", nil
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return exprTruth(right), nil
+}
+
+type compareNode struct {
+	op    string
+	left  exprNode
+	right exprNode
+	regex *regexp.Regexp
+}
+
+func (n *compareNode) eval(env *ExprEnv) (any, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "=~":
+		return n.regex.MatchString(exprString(left)), nil
+	case "in":
+		list, ok := right.([]string)
+		if !ok {
+			return nil, fmt.Errorf("'in' requires a list, got %T", right)
+		}
+		return slices.Contains(list, exprString(left)), nil
+	case "==":
+		return exprString(left) == exprString(right), nil
+	case "!=":
+		return exprString(left) != exprString(right), nil
+	}
+	a, aok := left.(float64)
+	b, bok := right.(float64)
+	if !aok || !bok {
+		return nil, fmt.Errorf("'%s' requires numbers, got %T and %T", n.op, left, right)
+	}
+	switch n.op {
+	case "<":
+		return a < b, nil
+	case "<=":
+		return a <= b, nil
+	case ">":
+		return a > b, nil
+	}
+	return a >= b, nil
+}
+
+type callNode struct {
+	name string
+	fn   exprFunc
+	args []exprNode
+}
+
+func (n *callNode) eval(env *ExprEnv) (any, error) {
+	args := []any{}
+	for _, arg := range n.args {
+		value, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, value)
+	}
+	value, err := n.fn.call(env, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s(): %v", n.name, err)
+	}
+	return value, nil
+}
+
+func exprTruth(value any) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case float64:
+		return v != 0
+	case []string:
+		return len(v) > 0
+	}
+	return false
+}
+
+func exprString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []string:
+		return strings.Join(v, ",")
+	}
+	return fmt.Sprintf("%v", value)
+}
@@ -0,0 +1,72 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestExprEval(t *testing.T) {
+	env := ExprEnv{
+		Vars: map[string]any{
+			"whitelisted": true,
+			"dkim":        "fail",
+			"books":       []string{"family", "work"},
+			"score":       float64(42),
+			"from":        "Joe@Example.com",
+		},
+		Headers: func(name string) []string {
+			if name == "List-Id" {
+				return []string{"<dev.lists.example.com>"}
+			}
+			return []string{}
+		},
+	}
+	variables := []string{"whitelisted", "dkim", "books", "score", "from"}
+	cases := []struct {
+		expr   string
+		result bool
+	}{
+		{`whitelisted && dkim != "pass"`, true},
+		{`whitelisted && !(dkim == 'fail')`, false},
+		{`"work" in books || false`, true},
+		{`score >= 40 && score < 50`, true},
+		{`from =~ "(?i)@example\\.com$"`, true},
+		{`domain(from) == "example.com"`, true},
+		{`matches(from, "*@example.*")`, true},
+		{`contains(header("List-Id"), "DEV.lists")`, true},
+		{`has_header("Precedence")`, false},
+		{`contains(books, "newsletters")`, false},
+	}
+	for _, c := range cases {
+		expr, err := CompileExpr(c.expr, variables)
+		require.Nil(t, err, c.expr)
+		result, err := expr.Test(&env)
+		require.Nil(t, err, c.expr)
+		require.Equal(t, c.result, result, c.expr)
+	}
+
+	expr, err := CompileExpr(`from > 3`, variables)
+	require.Nil(t, err)
+	_, err = expr.Test(&env)
+	require.ErrorContains(t, err, "requires numbers")
+}
+
+func TestExprCompileErrors(t *testing.T) {
+	variables := []string{"whitelisted"}
+	errors := map[string]string{
+		`whitelisted &&`:              "unexpected end",
+		`unknown_var`:                 "unknown variable 'unknown_var'",
+		`exec("rm")`:                  "unknown function 'exec'",
+		`header("a", "b")`:            "takes 1 argument(s), got 2",
+		`whitelisted =~ "["`:          "invalid regular expression",
+		`whitelisted =~ whitelisted`:  "requires a string literal",
+		`"unterminated`:               "unterminated string",
+		`whitelisted; true`:           "unexpected character ';'",
+		`(whitelisted`:                "expected ')'",
+		`matches(whitelisted, "/[/")`: "invalid pattern",
+	}
+	for text, message := range errors {
+		_, err := CompileExpr(text, variables)
+		require.ErrorContains(t, err, message, text)
+	}
+}
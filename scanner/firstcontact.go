@@ -0,0 +1,48 @@
+// detection of senders not seen before
+package scanner
+
+import (
+	"log"
+	"time"
+)
+
+const SENDERS_BUCKET = "senders"
+
+// senders not seen for this many days are forgotten unless first_contact.max_age_days is set
+const DEFAULT_SENDERS_MAX_AGE_DAYS = 365
+
+// the least recently seen senders beyond this many are forgotten unless first_contact.max_entries is set
+const DEFAULT_SENDERS_MAX_ENTRIES = 10000
+
+// a known sender's last-seen time is only updated once this old, so repeat senders do not rewrite the store
+const SENDERS_REFRESH = 24 * time.Hour
+
+// record the time each sender was last seen, reporting a sender not seen before
+func (s *Scanner) checkFirstContact() {
+	if !ViperGetBool("first_contact.check") || s.From == "" {
+		return
+	}
+	store, err := s.openStore()
+	if err != nil {
+		Warning("first contact check: %v", err)
+		return
+	}
+	defer s.releaseStore()
+	store.Prune(SENDERS_BUCKET, maxAge("first_contact.max_age_days", DEFAULT_SENDERS_MAX_AGE_DAYS))
+	entry := store.Get(SENDERS_BUCKET, s.From)
+	if entry == nil {
+		s.FirstContact = true
+		if s.verbose {
+			log.Printf("first contact from %s\n", s.From)
+		}
+		s.AddHeaderLine("X-FilterBooks-FirstContact: yes")
+	}
+	if entry == nil || time.Since(entry.Time) > SENDERS_REFRESH {
+		store.Put(SENDERS_BUCKET, s.From, "", time.Now())
+	}
+	maxEntries := DEFAULT_SENDERS_MAX_ENTRIES
+	if ViperGetInt("first_contact.max_entries") > 0 {
+		maxEntries = ViperGetInt("first_contact.max_entries")
+	}
+	store.Trim(SENDERS_BUCKET, maxEntries)
+}
@@ -0,0 +1,52 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestFirstContact(t *testing.T) {
+	initTestConfig(t)
+	ViperSet("cache_dir", t.TempDir())
+	ViperSet("first_contact.check", true)
+	defer ViperSet("cache_dir", "")
+	defer ViperSet("first_contact", nil)
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/jane@example.com/": scanResponse("family", "family"),
+		"/filterctl/scan/owner@example.org/pat@example.com/":  scanResponse(""),
+	}}
+	message := "From: Jane <jane@example.com>\n\nbody\n"
+
+	scanner, output := newTestScanner(t, message, client)
+	require.Nil(t, scanner.Scan())
+	require.True(t, scanner.FirstContact)
+	require.Equal(t, "yes", outputHeader(output, "X-FilterBooks-FirstContact"))
+
+	scanner, output = newTestScanner(t, message, client)
+	require.Nil(t, scanner.Scan())
+	require.False(t, scanner.FirstContact)
+	require.Equal(t, "", outputHeader(output, "X-FilterBooks-FirstContact"))
+
+	// a sender not seen within the retention period is a first contact again
+	store, err := OpenStore("owner@example.org")
+	require.Nil(t, err)
+	store.Put(SENDERS_BUCKET, "jane@example.com", "", time.Now().Add(-400*24*time.Hour))
+	require.Nil(t, store.Close())
+	scanner, output = newTestScanner(t, message, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "yes", outputHeader(output, "X-FilterBooks-FirstContact"))
+
+	// the least recently seen senders are forgotten beyond first_contact.max_entries
+	ViperSet("first_contact.max_entries", 1)
+	store, err = OpenStore("owner@example.org")
+	require.Nil(t, err)
+	store.Put(SENDERS_BUCKET, "jane@example.com", "", time.Now().Add(-48*time.Hour))
+	require.Nil(t, store.Close())
+	scanner, output = newTestScanner(t, "From: pat@example.com\n\nbody\n", client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "yes", outputHeader(output, "X-FilterBooks-FirstContact"))
+	scanner, output = newTestScanner(t, message, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "yes", outputHeader(output, "X-FilterBooks-FirstContact"))
+}
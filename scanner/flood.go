@@ -0,0 +1,131 @@
+// per-sender and per-domain message rate limits
+package scanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const FLOOD_SENDER_BUCKET = "flood-sender"
+const FLOOD_DOMAIN_BUCKET = "flood-domain"
+const LOOKUP_BUCKET = "lookup"
+
+const DEFAULT_FLOOD_WINDOW = "1h"
+
+type FloodConfig struct {
+	Window string `mapstructure:"window"`
+	Sender int    `mapstructure:"sender"`
+	Domain int    `mapstructure:"domain"`
+	window time.Duration
+}
+
+func LoadFloodConfig() (*FloodConfig, error) {
+	var config FloodConfig
+	err := viperUnmarshal("flood", &config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Window == "" {
+		config.Window = DEFAULT_FLOOD_WINDOW
+	}
+	config.window, err = time.ParseDuration(config.Window)
+	if err != nil || config.window <= 0 {
+		return nil, Fatalf("flood.window: invalid duration '%s'", config.Window)
+	}
+	return &config, nil
+}
+
+func (c *FloodConfig) Enabled() bool {
+	return c.Sender > 0 || c.Domain > 0
+}
+
+// count the messages from the sender and the sender's domain, reporting those over the thresholds
+func (s *Scanner) checkFlood() {
+	if !s.floodConfig.Enabled() || s.From == "" {
+		return
+	}
+	store, err := s.openStore()
+	if err != nil {
+		Warning("flood check: %v", err)
+		return
+	}
+	defer s.releaseStore()
+	now := time.Now()
+	since := now.Add(-s.floodConfig.window)
+	for _, bucket := range []string{FLOOD_SENDER_BUCKET, FLOOD_DOMAIN_BUCKET, LOOKUP_BUCKET} {
+		store.Prune(bucket, s.floodConfig.window)
+	}
+	// counts beyond one over a threshold are not kept, so a flood does not grow the store
+	window := s.floodConfig.Window
+	if threshold := s.floodConfig.Sender; threshold > 0 {
+		if count := store.Record(FLOOD_SENDER_BUCKET, s.From, now, since, threshold+1); count > threshold {
+			s.Flood = append(s.Flood, fmt.Sprintf("sender=%d/%s", count, window))
+		}
+	}
+	if threshold := s.floodConfig.Domain; threshold > 0 {
+		if count := store.Record(FLOOD_DOMAIN_BUCKET, addressDomain(s.From), now, since, threshold+1); count > threshold {
+			s.Flood = append(s.Flood, fmt.Sprintf("domain=%d/%s", count, window))
+		}
+	}
+	if len(s.Flood) > 0 {
+		log.Printf("flood: %s %s\n", s.From, strings.Join(s.Flood, " "))
+		s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Flood: %s", strings.Join(s.Flood, " ")))
+	}
+}
+
+// look up the From address, using the result cached within the flood window while the sender is flooding;
+// the store is only opened to read and update the cache, not held across the lookup
+func (s *Scanner) scanFrom() error {
+	if !s.floodConfig.Enabled() {
+		return s.ScanAddressBooks(s.Address, s.From)
+	}
+	if len(s.Flood) > 0 {
+		if response := s.cachedLookup(); response != nil {
+			if s.verbose {
+				log.Printf("flood: using cached lookup for %s\n", s.From)
+			}
+			s.Whitelisted = response.Whitelisted
+			s.Book = response.Book
+			s.Books = response.Books
+			return nil
+		}
+	}
+	err := s.ScanAddressBooks(s.Address, s.From)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(ScanResponse{Response: Response{Success: true}, Whitelisted: s.Whitelisted, Book: s.Book, Books: s.Books})
+	if err != nil {
+		return nil
+	}
+	store, err := s.openStore()
+	if err != nil {
+		Warning("flood cache: %v", err)
+		return nil
+	}
+	defer s.releaseStore()
+	store.Put(LOOKUP_BUCKET, s.From, string(data), time.Now())
+	return nil
+}
+
+// return the cached lookup of the From address, or nil
+func (s *Scanner) cachedLookup() *ScanResponse {
+	store, err := s.openStore()
+	if err != nil {
+		Warning("flood cache: %v", err)
+		return nil
+	}
+	defer s.releaseStore()
+	entry := store.Get(LOOKUP_BUCKET, s.From)
+	if entry == nil {
+		return nil
+	}
+	var response ScanResponse
+	if json.Unmarshal([]byte(entry.Value), &response) != nil {
+		return nil
+	}
+	return &response
+}
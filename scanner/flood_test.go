@@ -0,0 +1,55 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestFloodConfig(t *testing.T) {
+	initTestConfig(t)
+	defer ViperSet("flood", nil)
+	config, err := LoadFloodConfig()
+	require.Nil(t, err)
+	require.False(t, config.Enabled())
+	ViperSet("flood", map[string]any{"window": "soon", "sender": 5})
+	_, err = LoadFloodConfig()
+	require.NotNil(t, err)
+}
+
+func TestFlood(t *testing.T) {
+	initTestConfig(t)
+	ViperSet("cache_dir", t.TempDir())
+	ViperSet("flood", map[string]any{"window": "10m", "sender": 2, "domain": 3})
+	defer ViperSet("cache_dir", "")
+	defer ViperSet("flood", nil)
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/jane@example.com/": scanResponse("family", "family"),
+		"/filterctl/scan/owner@example.org/pat@example.com/":  scanResponse(""),
+	}}
+	// counts are kept up to one over each threshold
+	expected := []string{"", "", "sender=3/10m", "sender=3/10m domain=4/10m"}
+	for i, header := range expected {
+		scanner, output := newTestScanner(t, "From: jane@example.com\n\nbody\n", client)
+		require.Nil(t, scanner.Scan())
+		require.Equal(t, header, outputHeader(output, "X-FilterBooks-Flood"), i)
+		require.Equal(t, "family", outputHeader(output, "X-FilterBook"), i)
+	}
+	// flooded messages use the cached lookup
+	require.Len(t, client.requests, 2)
+
+	scanner, output := newTestScanner(t, "From: pat@example.com\n\nbody\n", client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "domain=4/10m", outputHeader(output, "X-FilterBooks-Flood"))
+	require.Len(t, client.requests, 3)
+	store, err := OpenStore("owner@example.org")
+	require.Nil(t, err)
+	require.Len(t, store.Get(FLOOD_DOMAIN_BUCKET, "example.com").Times, 4)
+	require.Nil(t, store.Close())
+
+	// the store is not held open across the filterctld lookup
+	scanner, _ = newTestScanner(t, "From: new@example.com\n\nbody\n", client)
+	client.responses["/filterctl/scan/owner@example.org/new@example.com/"] = scanResponse("")
+	client.onRequest = func() { require.Nil(t, scanner.store) }
+	require.Nil(t, scanner.Scan())
+	require.Len(t, client.requests, 4)
+}
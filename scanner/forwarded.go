@@ -0,0 +1,188 @@
+// original sender identities of forwarded and resent messages
+package scanner
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const FORWARDED_SOURCES = "resent arc x-original-from"
+
+type ForwardedConfig struct {
+	Sources        []string `mapstructure:"sources"`
+	TrustedSealers []string `mapstructure:"trusted_sealers"`
+}
+
+// an alternate author address, the header identifying it and its book
+type Identity struct {
+	Header  string
+	Address string
+	Book    string
+}
+
+func (i *Identity) String() string {
+	if i.Verified() {
+		return i.Header + " " + i.Address
+	}
+	return fmt.Sprintf("%s %s book=%s unverified", i.Header, i.Address, i.Book)
+}
+
+// only the ARC sender is authenticated; resent and x-original-from are set by the sender
+func (i *Identity) Verified() bool {
+	return i.Header == "arc"
+}
+
+func LoadForwardedConfig() (*ForwardedConfig, error) {
+	var config ForwardedConfig
+	err := viperUnmarshal("forwarded", &config)
+	if err != nil {
+		return nil, err
+	}
+	for i, source := range config.Sources {
+		config.Sources[i] = strings.ToLower(source)
+		if !slices.Contains(strings.Fields(FORWARDED_SOURCES), config.Sources[i]) {
+			return nil, Fatalf("forwarded.sources: unknown source '%s'", source)
+		}
+	}
+	for i, sealer := range config.TrustedSealers {
+		config.TrustedSealers[i] = strings.ToLower(sealer)
+	}
+	if slices.Contains(config.Sources, "arc") && len(config.TrustedSealers) == 0 {
+		return nil, Fatalf("forwarded.sources includes arc but forwarded.trusted_sealers is empty")
+	}
+	return &config, nil
+}
+
+// return the first address of the topmost instance of a header
+func (s *Scanner) headerAddress(name string) string {
+	addresses := parseAddressList(s.HeaderValue(name))
+	if len(addresses) == 0 {
+		return ""
+	}
+	return addresses[0]
+}
+
+// return true if a trusted Authentication-Results header reports a valid ARC chain
+func (s *Scanner) arcValidated() bool {
+	for _, results := range s.trustedAuthResults() {
+		for _, result := range results.Results {
+			if result.Method == "arc" && result.Result == "pass" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// return the SPF-authenticated envelope sender recorded by the first trusted ARC sealer
+func (s *Scanner) arcOriginalSender() string {
+	if !s.arcValidated() {
+		if s.verbose {
+			log.Println("ignoring ARC headers without a trusted arc=pass result")
+		}
+		return ""
+	}
+	trusted := make(map[int]bool)
+	for _, seal := range s.HeaderValues("arc-seal") {
+		tags := parseTagList(seal)
+		instance, err := strconv.Atoi(tags["i"])
+		if err == nil && slices.Contains(s.forwarded.TrustedSealers, strings.ToLower(tags["d"])) {
+			trusted[instance] = true
+		}
+	}
+	sender := ""
+	first := 0
+	for _, value := range s.HeaderValues("arc-authentication-results") {
+		tag, rest, found := strings.Cut(value, ";")
+		if !found {
+			continue
+		}
+		instance, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(tag), "i=")))
+		if err != nil || !trusted[instance] || (first != 0 && instance > first) {
+			continue
+		}
+		results, err := ParseAuthResults(rest)
+		if err != nil {
+			Warning("ARC-Authentication-Results: %v", err)
+			continue
+		}
+		for _, result := range results.Results {
+			mailfrom := strings.ToLower(result.Props["smtp.mailfrom"])
+			if result.Method == "spf" && result.Result == "pass" && VALID_EMAIL_ADDRESS.MatchString(mailfrom) {
+				sender = mailfrom
+				first = instance
+			}
+		}
+	}
+	return sender
+}
+
+// the alternate author identities named by the configured sources, in order
+func (s *Scanner) alternateIdentities() []*Identity {
+	identities := []*Identity{}
+	add := func(header, address string) {
+		if address == "" || address == s.From {
+			return
+		}
+		for _, identity := range identities {
+			if identity.Address == address {
+				return
+			}
+		}
+		identities = append(identities, &Identity{Header: header, Address: address})
+	}
+	for _, source := range s.forwarded.Sources {
+		switch source {
+		case "resent":
+			add("resent-from", s.headerAddress("resent-from"))
+			add("resent-sender", s.headerAddress("resent-sender"))
+		case "arc":
+			add("arc", s.arcOriginalSender())
+		case "x-original-from":
+			add("x-original-from", s.headerAddress("x-original-from"))
+		}
+	}
+	return identities
+}
+
+// look up the alternate identities of an unlisted From, adopting the books of
+// the first verified one listed; a listed unverified identity is only reported
+func (s *Scanner) checkForwarded() {
+	if len(s.forwarded.Sources) == 0 || s.Whitelisted || s.Book != "" {
+		return
+	}
+	var unverified *Identity
+	for _, identity := range s.alternateIdentities() {
+		response, err := s.lookupAddress(identity.Address)
+		if err != nil {
+			Warning("%s lookup: %v", identity.Header, err)
+			continue
+		}
+		if s.verbose {
+			log.Printf("identity %s: whitelisted=%v book=%s\n", identity, response.Whitelisted, response.Book)
+		}
+		if !response.Listed() {
+			continue
+		}
+		identity.Book = response.Book
+		if !identity.Verified() {
+			if unverified == nil {
+				unverified = identity
+			}
+			continue
+		}
+		s.Identity = identity
+		s.Whitelisted = response.Whitelisted
+		s.Book = response.Book
+		s.Books = response.Books
+		s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Identity: %s", identity))
+		return
+	}
+	if unverified != nil {
+		s.Identity = unverified
+		s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Identity: %s", unverified))
+	}
+}
@@ -0,0 +1,81 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestForwardedConfig(t *testing.T) {
+	initTestConfig(t)
+	defer ViperSet("forwarded", nil)
+	ViperSet("forwarded", map[string]any{"sources": []string{"resent", "bogus"}})
+	_, err := LoadForwardedConfig()
+	require.NotNil(t, err)
+	ViperSet("forwarded", map[string]any{"sources": []string{"arc"}})
+	_, err = LoadForwardedConfig()
+	require.NotNil(t, err)
+}
+
+func TestForwardedIdentity(t *testing.T) {
+	initTestConfig(t)
+	ViperSet("forwarded", map[string]any{
+		"sources":         []string{"resent", "arc", "x-original-from"},
+		"trusted_sealers": []string{"lists.example"},
+	})
+	ViperSet("auth.authserv_id", []string{"mx.example.org"})
+	defer ViperSet("forwarded", nil)
+	defer ViperSet("auth", nil)
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/list@lists.example/":  scanResponse(""),
+		"/filterctl/scan/owner@example.org/jane@example.com/":    scanResponse("family", "family"),
+		"/filterctl/scan/owner@example.org/fwd@forward.example/": scanResponse(""),
+	}}
+
+	message := "From: Jane via List <list@lists.example>\nX-Original-From: Jane <jane@example.com>\n\nbody\n"
+	scanner, output := newTestScanner(t, message, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "x-original-from jane@example.com book=family unverified", outputHeader(output, "X-FilterBooks-Identity"))
+	require.Equal(t, "x-original-from", scanner.Identity.Header)
+
+	// a forged X-Original-From does not whitelist the message
+	require.False(t, scanner.Whitelisted)
+	require.Equal(t, "", scanner.Book)
+	require.Equal(t, "", outputHeader(output, "X-FilterBook"))
+
+	message = "Resent-From: fwd@forward.example\nFrom: list@lists.example\nResent-Sender: jane@example.com\n\nbody\n"
+	scanner, output = newTestScanner(t, message, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "resent-sender jane@example.com book=family unverified", outputHeader(output, "X-FilterBooks-Identity"))
+	require.False(t, scanner.Whitelisted)
+	require.Equal(t, []string{
+		"GET /filterctl/scan/owner@example.org/list@lists.example/",
+		"GET /filterctl/scan/owner@example.org/fwd@forward.example/",
+		"GET /filterctl/scan/owner@example.org/jane@example.com/",
+	}, client.requests[len(client.requests)-3:])
+
+	arc := "ARC-Seal: i=1; a=rsa-sha256; d=lists.example; s=arc; cv=none; b=xyz\n" +
+		"ARC-Authentication-Results: i=1; mx.lists.example; spf=pass smtp.mailfrom=jane@example.com\n" +
+		"From: list@lists.example\n\nbody\n"
+
+	// ARC results are ignored without a trusted arc=pass
+	scanner, output = newTestScanner(t, arc, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "", outputHeader(output, "X-FilterBooks-Identity"))
+
+	scanner, output = newTestScanner(t, "Authentication-Results: mx.example.org; arc=pass\n"+arc, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "arc jane@example.com", outputHeader(output, "X-FilterBooks-Identity"))
+	require.Equal(t, "family", outputHeader(output, "X-FilterBook"))
+	require.True(t, scanner.Whitelisted)
+
+	// the verified ARC sender is preferred to an unverified identity
+	scanner, output = newTestScanner(t, "Authentication-Results: mx.example.org; arc=pass\nX-Original-From: fwd@forward.example\n"+arc, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "arc jane@example.com", outputHeader(output, "X-FilterBooks-Identity"))
+
+	// a listed From is not replaced
+	scanner, output = newTestScanner(t, "From: jane@example.com\nX-Original-From: fwd@forward.example\n\nbody\n", client)
+	require.Nil(t, scanner.Scan())
+	require.Nil(t, scanner.Identity)
+	require.Equal(t, "", outputHeader(output, "X-FilterBooks-Identity"))
+}
@@ -0,0 +1,34 @@
+// display-name impersonation of address book contacts
+package scanner
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// report a From display name matching a contact when the From address is not a known address
+func (s *Scanner) checkImpersonation() {
+	if s.FromName == "" || s.Whitelisted || s.Book != "" || len(s.contacts.ByAddress(s.From)) > 0 {
+		return
+	}
+	candidates := s.contacts.ByName(s.FromName)
+	// a display name that is itself an address, as in "jane@example.com" <x@example.net>
+	for _, word := range strings.Fields(strings.ToLower(s.FromName)) {
+		word = strings.Trim(word, `"'<>()`)
+		if VALID_EMAIL_ADDRESS.MatchString(word) {
+			candidates = append(candidates, s.contacts.ByAddress(word)...)
+		}
+	}
+	for _, contact := range candidates {
+		if contact.Address == s.From {
+			continue
+		}
+		s.Impersonation = contact
+		if s.verbose {
+			log.Printf("impersonation: '%s' <%s> claims contact %s in %s\n", s.FromName, s.From, contact.Address, contact.Book)
+		}
+		s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Impersonation: \"%s\" <%s> book=%s", contact.Name, contact.Address, contact.Book))
+		return
+	}
+}
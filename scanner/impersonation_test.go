@@ -0,0 +1,51 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeContacts(t *testing.T) {
+	contactsFile := filepath.Join(t.TempDir(), "contacts.csv")
+	contacts := "# book,address,name\n" +
+		"family,jane@example.com,\"Doe, Jane\"\n" +
+		"work,boss@corp.example,Pat Boss\n" +
+		"work,pat@corp.example,Pat Boss\n"
+	require.Nil(t, os.WriteFile(contactsFile, []byte(contacts), 0600))
+	ViperSet("contacts.file", contactsFile)
+}
+
+func TestNormalizeName(t *testing.T) {
+	require.Equal(t, "jane doe", normalizeName("Doe, Jane"))
+	require.Equal(t, "jane doe", normalizeName(`"JANE   DOE."`))
+	require.Equal(t, "jane@example.com", normalizeName("'jane@example.com'"))
+	require.Equal(t, "", normalizeName("JD"))
+}
+
+func TestImpersonation(t *testing.T) {
+	initTestConfig(t)
+	writeContacts(t)
+	defer ViperSet("contacts.file", "")
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/jane.doe@freemail.example/": scanResponse(""),
+		"/filterctl/scan/owner@example.org/pat@corp.example/":          scanResponse(""),
+		"/filterctl/scan/owner@example.org/attacker@evil.example/":     scanResponse(""),
+	}}
+
+	scanner, output := newTestScanner(t, "From: =?utf-8?q?Jane_Doe?= <jane.doe@freemail.example>\n\nbody\n", client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "Jane Doe", scanner.FromName)
+	require.Equal(t, `"Doe, Jane" <jane@example.com> book=family`, outputHeader(output, "X-FilterBooks-Impersonation"))
+
+	scanner, output = newTestScanner(t, "From: \"jane@example.com\" <attacker@evil.example>\n\nbody\n", client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, `"Doe, Jane" <jane@example.com> book=family`, outputHeader(output, "X-FilterBooks-Impersonation"))
+
+	// a second known address for the same contact is not an impersonation
+	scanner, output = newTestScanner(t, "From: Pat Boss <pat@corp.example>\n\nbody\n", client)
+	require.Nil(t, scanner.Scan())
+	require.Nil(t, scanner.Impersonation)
+	require.Equal(t, "", outputHeader(output, "X-FilterBooks-Impersonation"))
+}
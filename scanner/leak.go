@@ -0,0 +1,37 @@
+// detection of sub-addresses used by senders other than the first
+package scanner
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+const DETAIL_BUCKET = "detail"
+
+// record the first sender domain of each recipient detail, reporting mail to the detail from another domain
+func (s *Scanner) checkLeak() {
+	if !ViperGetBool("leak.check") || s.Detail == "" || s.From == "" {
+		return
+	}
+	store, err := s.openStore()
+	if err != nil {
+		Warning("leak check: %v", err)
+		return
+	}
+	defer s.releaseStore()
+	entry := store.Get(DETAIL_BUCKET, s.Detail)
+	if entry == nil {
+		store.Put(DETAIL_BUCKET, s.Detail, s.From, time.Now())
+		if s.verbose {
+			log.Printf("detail %s first used by %s\n", s.Detail, s.From)
+		}
+		return
+	}
+	if domainAligned(addressDomain(entry.Value), addressDomain(s.From)) {
+		return
+	}
+	s.Leak = entry.Value
+	log.Printf("leak: detail %s issued to %s used by %s\n", s.Detail, entry.Value, s.From)
+	s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Leak: %s first=%s since=%s", s.Detail, entry.Value, entry.Time.Format(time.RFC3339)))
+}
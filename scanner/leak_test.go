@@ -0,0 +1,36 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestLeak(t *testing.T) {
+	initTestConfig(t)
+	ViperSet("cache_dir", t.TempDir())
+	ViperSet("leak.check", true)
+	defer ViperSet("cache_dir", "")
+	defer ViperSet("leak", nil)
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/orders@shop.example/":    scanResponse(""),
+		"/filterctl/scan/owner@example.org/news@mail.shop.example/": scanResponse(""),
+		"/filterctl/scan/owner@example.org/deals@spam.example/":     scanResponse(""),
+	}}
+
+	for _, test := range []struct{ from, leak string }{
+		{"orders@shop.example", ""},
+		{"news@mail.shop.example", ""},
+		{"deals@spam.example", "orders@shop.example"},
+	} {
+		scanner, output := newTestScanner(t, "From: "+test.from+"\nTo: owner+shop@example.org\n\nbody\n", client)
+		require.Nil(t, scanner.Scan())
+		require.Equal(t, test.leak, scanner.Leak, test.from)
+		header := outputHeader(output, "X-FilterBooks-Leak")
+		if test.leak == "" {
+			require.Equal(t, "", header)
+		} else {
+			require.True(t, strings.HasPrefix(header, "shop first=orders@shop.example since="), header)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// learning of outbound correspondents into an address book
+package scanner
+
+import (
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+const LEARNED_BUCKET = "learned"
+
+const DEFAULT_LEARN_BOOK = "correspondents"
+const DEFAULT_LEARN_PATH = "/filterctl/address/"
+
+// recipients not learned unless learn.exclude is set
+var DEFAULT_LEARN_EXCLUDE = []string{"*noreply*@*", "*no-reply*@*", "*donotreply*@*", "mailer-daemon@*", "postmaster@*"}
+
+type LearnConfig struct {
+	Book    string   `mapstructure:"book"`
+	Path    string   `mapstructure:"path"`
+	Method  string   `mapstructure:"method"`
+	Exclude []string `mapstructure:"exclude"`
+}
+
+type Learner struct {
+	LearnConfig
+	exclude []*Pattern
+	dryRun  bool
+}
+
+// the request sent to filterctld to add an address to a book
+type AddAddressRequest struct {
+	Username string `json:"Username"`
+	Bookname string `json:"Bookname"`
+	Address  string `json:"Address"`
+	Name     string `json:"Name"`
+}
+
+func LoadLearner() (*Learner, error) {
+	var config LearnConfig
+	err := viperUnmarshal("learn", &config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Book == "" {
+		config.Book = DEFAULT_LEARN_BOOK
+	}
+	if config.Path == "" {
+		config.Path = DEFAULT_LEARN_PATH
+	}
+	switch strings.ToLower(config.Method) {
+	case "", "post":
+		config.Method = "post"
+	case "put":
+		config.Method = "put"
+	default:
+		return nil, Fatalf("learn.method: expected 'post' or 'put', got '%s'", config.Method)
+	}
+	if config.Exclude == nil {
+		config.Exclude = DEFAULT_LEARN_EXCLUDE
+	}
+	exclude, err := NewPatterns(config.Exclude)
+	if err != nil {
+		return nil, Fatalf("learn.exclude: %v", err)
+	}
+	return &Learner{LearnConfig: config, exclude: exclude, dryRun: ViperGetBool("learn.dry_run")}, nil
+}
+
+func (l *Learner) Excluded(address string) bool {
+	for _, pattern := range l.exclude {
+		if pattern.Match(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// return the To, Cc and Bcc recipient addresses in header order, and their display names
+func (s *Scanner) outboundRecipients() ([]string, map[string]string) {
+	addresses := []string{}
+	names := make(map[string]string)
+	for _, header := range []string{"to", "cc", "bcc"} {
+		for _, value := range s.HeaderValues(header) {
+			for _, address := range parseNamedAddressList(value) {
+				if !slices.Contains(addresses, address.Address) {
+					addresses = append(addresses, address.Address)
+				}
+				if names[address.Address] == "" {
+					names[address.Address] = address.Name
+				}
+			}
+		}
+	}
+	return addresses, names
+}
+
+// add an address to a book through filterctld using the learn method and path
+func (s *Scanner) addAddress(learner *Learner, book, address, name string) error {
+	request := AddAddressRequest{Username: s.Address, Bookname: book, Address: address, Name: name}
+	var response Response
+	var err error
+	if learner.Method == "put" {
+		_, err = s.client.Put(learner.Path, &request, &response, nil)
+	} else {
+		_, err = s.client.Post(learner.Path, &request, &response, nil)
+	}
+	if err != nil {
+		return Fatal(err)
+	}
+	if !response.Success {
+		return Fatalf("add address request failed: %v", response.Message)
+	}
+	return nil
+}
+
+// ScanLearn adds the recipients of a message sent by the owner to the learn book and writes the message unchanged
+func (s *Scanner) ScanLearn() error {
+	learner, err := LoadLearner()
+	if err != nil {
+		return Fatal(err)
+	}
+	err = s.ReadHeader()
+	if err != nil {
+		return Fatal(err)
+	}
+	err = s.setAddress()
+	if err != nil {
+		return Fatal(err)
+	}
+	// read the learned set, releasing the store lock before the filterctld requests
+	store, err := s.openStore()
+	if err != nil {
+		return Fatal(err)
+	}
+	addresses, names := s.outboundRecipients()
+	candidates := []string{}
+	for _, address := range addresses {
+		switch {
+		case baseAddress(address) == baseAddress(s.Address) || baseAddress(address) == baseAddress(s.From):
+			continue
+		case learner.Excluded(address):
+			if s.verbose {
+				log.Printf("learn: excluded %s\n", address)
+			}
+			continue
+		case store.Get(LEARNED_BUCKET, address) != nil:
+			continue
+		}
+		candidates = append(candidates, address)
+	}
+	err = s.closeStore()
+	if err != nil {
+		return Fatal(err)
+	}
+	learned := []string{}
+	for _, address := range candidates {
+		// a filterctld failure must not hold up outgoing mail; the address is tried again next time
+		response, err := s.lookupAddress(address)
+		if err != nil {
+			Warning("learn: %s lookup: %v", address, err)
+			continue
+		}
+		if !response.Listed() {
+			if learner.dryRun {
+				log.Printf("learn: would add %s to %s\n", address, learner.Book)
+				continue
+			}
+			err = s.addAddress(learner, learner.Book, address, names[address])
+			if err != nil {
+				Warning("learn: adding %s to %s: %v", address, learner.Book, err)
+				continue
+			}
+			log.Printf("learn: added %s to %s\n", address, learner.Book)
+			s.Learned = append(s.Learned, address)
+		}
+		// remember addresses already in a book too, so they are not looked up again
+		learned = append(learned, address)
+	}
+	if len(learned) > 0 {
+		store, err = s.openStore()
+		if err != nil {
+			return Fatal(err)
+		}
+		for _, address := range learned {
+			store.Put(LEARNED_BUCKET, address, learner.Book, time.Now())
+		}
+		err = s.closeStore()
+		if err != nil {
+			return Fatal(err)
+		}
+	}
+	_, err = s.WriteHeader()
+	if err != nil {
+		return Fatal(err)
+	}
+	_, err = s.WriteMessage()
+	if err != nil {
+		return Fatal(err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestLearn(t *testing.T) {
+	initTestConfig(t)
+	ViperSet("cache_dir", t.TempDir())
+	ViperSet("learn.exclude", []string{"@lists.example", "*noreply*@*"})
+	defer ViperSet("cache_dir", "")
+	defer ViperSet("learn", nil)
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/new@example.net/":  scanResponse(""),
+		"/filterctl/scan/owner@example.org/jane@example.com/": scanResponse("family", "family"),
+		"/filterctl/address/": Response{Success: true},
+	}}
+	message := "From: Owner <owner@example.org>\n" +
+		"To: New Person <new@example.net>, jane@example.com\n" +
+		"Cc: owner+copy@example.org, dev@lists.example, noreply@example.com\n" +
+		"Message-Id: <sent-1@example.org>\n\nhello\n"
+
+	scanner, output := newTestScanner(t, message, client)
+	// the store is not held open across the filterctld requests
+	client.onRequest = func() { require.Nil(t, scanner.store) }
+	require.Nil(t, scanner.ScanLearn())
+	client.onRequest = nil
+	require.Equal(t, message, output.String())
+	require.Equal(t, []string{"new@example.net"}, scanner.Learned)
+	require.Equal(t, []string{
+		"GET /filterctl/scan/owner@example.org/new@example.net/",
+		"POST /filterctl/address/",
+		"GET /filterctl/scan/owner@example.org/jane@example.com/",
+	}, client.requests)
+
+	// learned and booked addresses are not looked up again
+	client.requests = nil
+	scanner, _ = newTestScanner(t, message, client)
+	require.Nil(t, scanner.ScanLearn())
+	require.Empty(t, scanner.Learned)
+	require.Empty(t, client.requests)
+
+	// a filterctld failure passes the message through and is retried later
+	ViperSet("cache_dir", t.TempDir())
+	failing := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/jane@example.com/": scanResponse("family", "family"),
+	}}
+	scanner, output = newTestScanner(t, message, failing)
+	require.Nil(t, scanner.ScanLearn())
+	require.Equal(t, message, output.String())
+	require.Empty(t, scanner.Learned)
+	client.requests = nil
+	scanner, _ = newTestScanner(t, message, client)
+	require.Nil(t, scanner.ScanLearn())
+	require.Equal(t, []string{"new@example.net"}, scanner.Learned)
+
+	ViperSet("cache_dir", t.TempDir())
+	ViperSet("learn.dry_run", true)
+	client.requests = nil
+	scanner, _ = newTestScanner(t, message, client)
+	require.Nil(t, scanner.ScanLearn())
+	require.Empty(t, scanner.Learned)
+	require.NotContains(t, client.requests, "POST /filterctl/address/")
+}
+
+func TestLearnConfig(t *testing.T) {
+	initTestConfig(t)
+	defer ViperSet("learn", nil)
+	learner, err := LoadLearner()
+	require.Nil(t, err)
+	require.Equal(t, DEFAULT_LEARN_BOOK, learner.Book)
+	require.True(t, learner.Excluded("no-reply@shop.example"))
+	ViperSet("learn.method", "delete")
+	_, err = LoadLearner()
+	require.NotNil(t, err)
+}
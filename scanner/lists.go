@@ -0,0 +1,60 @@
+// mailing list recognition
+package scanner
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var LIST_POST_MAILTO = regexp.MustCompile(`(?i)<mailto:([^>?]+)`)
+
+// return the posting address of a List-Post value, or an empty string for NO or a non-mailto URL
+func parseListPost(value string) string {
+	matches := LIST_POST_MAILTO.FindStringSubmatch(value)
+	if matches == nil {
+		return ""
+	}
+	address, err := url.PathUnescape(strings.TrimSpace(matches[1]))
+	if err != nil || !VALID_EMAIL_ADDRESS.MatchString(address) {
+		return ""
+	}
+	return strings.ToLower(address)
+}
+
+// look up the list identifier and posting address, filing an unlisted From into the book of the list
+func (s *Scanner) checkList() {
+	if !ViperGetBool("lists.lookup") || (s.ListId == "" && s.ListPost == "") {
+		return
+	}
+	for _, key := range []string{s.ListId, s.ListPost} {
+		if key == "" {
+			continue
+		}
+		response, err := s.lookupAddress(key)
+		if err != nil {
+			Warning("list lookup: %v", err)
+			continue
+		}
+		if s.verbose {
+			log.Printf("list %s: whitelisted=%v book=%s\n", key, response.Whitelisted, response.Book)
+		}
+		if !response.Listed() {
+			continue
+		}
+		s.ListBook = response.Book
+		list := s.ListId
+		if list == "" {
+			list = s.ListPost
+		}
+		s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-List: %s list=%s", response.Book, list))
+		if !(s.Whitelisted || s.Book != "") {
+			s.Whitelisted = response.Whitelisted
+			s.Book = response.Book
+			s.Books = response.Books
+		}
+		return
+	}
+}
@@ -0,0 +1,46 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseListPost(t *testing.T) {
+	require.Equal(t, "dev@lists.example", parseListPost("<mailto:Dev@Lists.Example>"))
+	require.Equal(t, "dev@lists.example", parseListPost("<https://lists.example/post>, <mailto:dev@lists.example?subject=x>"))
+	require.Equal(t, "", parseListPost("NO (posting not allowed)"))
+}
+
+func TestListLookup(t *testing.T) {
+	initTestConfig(t)
+	ViperSet("lists.lookup", true)
+	defer ViperSet("lists", nil)
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/poster@example.net/": scanResponse(""),
+		"/filterctl/scan/owner@example.org/dev.lists.example/":  scanResponse("lists", "lists"),
+		"/filterctl/scan/owner@example.org/jane@example.com/":   scanResponse("family", "family"),
+		"/filterctl/scan/owner@example.org/misc.lists.example/": scanResponse(""),
+		"/filterctl/scan/owner@example.org/misc@lists.example/": scanResponse("misc", "misc"),
+	}}
+
+	message := "From: poster@example.net\nList-Id: Developers <dev.lists.example>\nList-Post: <mailto:dev@lists.example>\n\nbody\n"
+	scanner, output := newTestScanner(t, message, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "dev.lists.example", scanner.ListId)
+	require.Equal(t, "dev@lists.example", scanner.ListPost)
+	require.Equal(t, "lists list=dev.lists.example", outputHeader(output, "X-FilterBooks-List"))
+	require.Equal(t, "lists", outputHeader(output, "X-FilterBook"))
+
+	// the posting address is looked up when the list identifier is in no book
+	message = "From: poster@example.net\nList-Id: <misc.lists.example>\nList-Post: <mailto:misc@lists.example>\n\nbody\n"
+	scanner, output = newTestScanner(t, message, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "misc list=misc.lists.example", outputHeader(output, "X-FilterBooks-List"))
+
+	// the book of a listed From is kept
+	message = "From: jane@example.com\nList-Id: <dev.lists.example>\n\nbody\n"
+	scanner, output = newTestScanner(t, message, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "lists list=dev.lists.example", outputHeader(output, "X-FilterBooks-List"))
+	require.Equal(t, "family", outputHeader(output, "X-FilterBook"))
+}
@@ -0,0 +1,290 @@
+// detection of sender domains resembling book domains
+package scanner
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// characters commonly substituted for latin letters in lookalike domains
+var CONFUSABLES = map[rune]rune{
+	// cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c', 'у': 'y', 'х': 'x', 'ѕ': 's',
+	'і': 'i', 'ј': 'j', 'һ': 'h', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'ӏ': 'l', 'ү': 'y', 'к': 'k',
+	'м': 'm', 'н': 'h', 'т': 't', 'ь': 'b',
+	// greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't',
+	'υ': 'u', 'χ': 'x', 'γ': 'y',
+	// latin variants
+	'ı': 'i', 'ł': 'l', 'ɑ': 'a', 'ǀ': 'l', 'ɩ': 'i', 'ɡ': 'g', 'ſ': 's',
+	'à': 'a', 'á': 'a', 'â': 'a', 'ã': 'a', 'ä': 'a', 'å': 'a', 'ā': 'a', 'ą': 'a',
+	'ç': 'c', 'ć': 'c', 'č': 'c', 'ď': 'd', 'đ': 'd',
+	'è': 'e', 'é': 'e', 'ê': 'e', 'ë': 'e', 'ē': 'e', 'ę': 'e', 'ě': 'e',
+	'ì': 'i', 'í': 'i', 'î': 'i', 'ï': 'i', 'ī': 'i',
+	'ñ': 'n', 'ń': 'n', 'ň': 'n',
+	'ò': 'o', 'ó': 'o', 'ô': 'o', 'õ': 'o', 'ö': 'o', 'ø': 'o', 'ō': 'o',
+	'ŕ': 'r', 'ř': 'r', 'ś': 's', 'š': 's', 'ş': 's', 'ť': 't',
+	'ù': 'u', 'ú': 'u', 'û': 'u', 'ü': 'u', 'ū': 'u', 'ů': 'u',
+	'ý': 'y', 'ÿ': 'y', 'ź': 'z', 'ż': 'z', 'ž': 'z',
+	// digits
+	'0': 'o', '1': 'l',
+}
+
+// letter sequences that render like a single letter
+var CONFUSABLE_SEQUENCES = strings.NewReplacer("rn", "m", "vv", "w", "cl", "d")
+
+// longest edit distance reported as a typo, and the shortest name it applies to
+const LOOKALIKE_MAX_DISTANCE = 1
+const LOOKALIKE_MIN_NAME = 5
+
+type lookalikeDomain struct {
+	domain   string
+	name     string
+	tld      string
+	skeleton string
+}
+
+type LookalikeIndex struct {
+	domains []*lookalikeDomain
+	exact   map[string]bool
+}
+
+// LoadLookalikeIndex indexes the domains of the contacts and the configured lookalike.domains
+func LoadLookalikeIndex(contacts *ContactIndex) *LookalikeIndex {
+	index := LookalikeIndex{domains: []*lookalikeDomain{}, exact: make(map[string]bool)}
+	domains := ViperGetStringSlice("lookalike.domains")
+	for _, contact := range contacts.Contacts {
+		domains = append(domains, addressDomain(contact.Address))
+	}
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "@"))
+		if domain == "" || index.exact[domain] {
+			continue
+		}
+		index.exact[domain] = true
+		// compare organizational domains, so subdomains on either side are covered
+		organization := organizationalDomain(domain)
+		if organization == "" {
+			organization = domain
+		}
+		index.exact[organization] = true
+		name, tld := splitDomain(organization)
+		index.domains = append(index.domains, &lookalikeDomain{
+			domain:   domain,
+			name:     name,
+			tld:      tld,
+			skeleton: skeleton(organization),
+		})
+	}
+	return &index
+}
+
+// split a domain into the part before the top level domain and the top level domain
+func splitDomain(domain string) (string, string) {
+	i := strings.LastIndex(domain, ".")
+	if i < 0 {
+		return domain, ""
+	}
+	return domain[:i], domain[i+1:]
+}
+
+// decode the punycode labels of an internationalized domain name
+func unicodeDomain(domain string) string {
+	labels := strings.Split(strings.ToLower(domain), ".")
+	for i, label := range labels {
+		if strings.HasPrefix(label, "xn--") {
+			decoded, err := punycodeDecode(label[4:])
+			if err == nil {
+				labels[i] = decoded
+			}
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+// map a domain to the latin form it resembles
+func skeleton(domain string) string {
+	var b strings.Builder
+	for _, r := range unicodeDomain(domain) {
+		if mapped, ok := CONFUSABLES[r]; ok {
+			r = mapped
+		}
+		b.WriteRune(r)
+	}
+	return CONFUSABLE_SEQUENCES.Replace(b.String())
+}
+
+// return true if any label of the domain mixes letters of more than one script
+func mixedScript(domain string) bool {
+	scripts := []*unicode.RangeTable{unicode.Latin, unicode.Cyrillic, unicode.Greek}
+	for _, label := range strings.Split(unicodeDomain(domain), ".") {
+		found := 0
+		for _, script := range scripts {
+			if strings.ContainsFunc(label, func(r rune) bool { return unicode.Is(script, r) }) {
+				found++
+			}
+		}
+		if found > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// optimal string alignment distance, abandoned once it must exceed limit
+func editDistance(a, b string, limit int) int {
+	s, t := []rune(a), []rune(b)
+	if len(s)-len(t) > limit || len(t)-len(s) > limit {
+		return limit + 1
+	}
+	prev2 := make([]int, len(t)+1)
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(t)]
+}
+
+// return the indexed domain resembling domain and the reasons, or nil if there is none
+func (l *LookalikeIndex) Match(domain string) (string, []string) {
+	domain = strings.ToLower(domain)
+	if domain == "" || l.exact[domain] {
+		return "", nil
+	}
+	organization := organizationalDomain(domain)
+	if organization == "" {
+		organization = domain
+	}
+	if l.exact[organization] {
+		return "", nil
+	}
+	name, tld := splitDomain(organization)
+	senderSkeleton := skeleton(organization)
+	for _, legit := range l.domains {
+		reasons := []string{}
+		switch {
+		case senderSkeleton == legit.skeleton:
+			reasons = append(reasons, "homoglyph")
+		case name == legit.name && tld != legit.tld:
+			reasons = append(reasons, "tld-swap")
+		case tld == legit.tld && len([]rune(legit.name)) >= LOOKALIKE_MIN_NAME &&
+			editDistance(name, legit.name, LOOKALIKE_MAX_DISTANCE) <= LOOKALIKE_MAX_DISTANCE:
+			reasons = append(reasons, "typo")
+		default:
+			continue
+		}
+		if mixedScript(domain) {
+			reasons = append(reasons, "mixed-script")
+		}
+		return legit.domain, reasons
+	}
+	return "", nil
+}
+
+// report a From domain resembling a book domain when the From address is not in a book
+func (s *Scanner) checkLookalike() {
+	if s.Whitelisted || s.Book != "" {
+		return
+	}
+	legit, reasons := s.lookalikes.Match(addressDomain(s.From))
+	if legit == "" {
+		return
+	}
+	s.Lookalike = legit
+	if s.verbose {
+		log.Printf("lookalike: %s resembles %s (%s)\n", addressDomain(s.From), legit, strings.Join(reasons, ","))
+	}
+	s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Lookalike: %s reason=%s", legit, strings.Join(reasons, ",")))
+}
+
+// decode a punycode string as described in RFC 3492
+func punycodeDecode(input string) (string, error) {
+	const base, tmin, tmax, skew, damp = 36, 1, 26, 38, 700
+	adapt := func(delta, points int, first bool) int {
+		if first {
+			delta /= damp
+		} else {
+			delta /= 2
+		}
+		delta += delta / points
+		k := 0
+		for delta > ((base-tmin)*tmax)/2 {
+			delta /= base - tmin
+			k += base
+		}
+		return k + (base-tmin+1)*delta/(delta+skew)
+	}
+	output := []rune{}
+	if i := strings.LastIndex(input, "-"); i >= 0 {
+		output = []rune(input[:i])
+		input = input[i+1:]
+	}
+	n, bias, i := 128, 72, 0
+	for pos := 0; pos < len(input); {
+		oldi, w := i, 1
+		for k := base; ; k += base {
+			if pos >= len(input) {
+				return "", Fatalf("truncated punycode")
+			}
+			c := input[pos]
+			pos++
+			var digit int
+			switch {
+			case c >= '0' && c <= '9':
+				digit = int(c-'0') + 26
+			case c >= 'a' && c <= 'z':
+				digit = int(c - 'a')
+			case c >= 'A' && c <= 'Z':
+				digit = int(c - 'A')
+			default:
+				return "", Fatalf("invalid punycode digit '%c'", c)
+			}
+			i += digit * w
+			t := k - bias
+			if t < tmin {
+				t = tmin
+			} else if t > tmax {
+				t = tmax
+			}
+			if digit < t {
+				break
+			}
+			w *= base - t
+			if w > unicode.MaxRune*base {
+				return "", Fatalf("punycode overflow")
+			}
+		}
+		bias = adapt(i-oldi, len(output)+1, oldi == 0)
+		n += i / (len(output) + 1)
+		i %= len(output) + 1
+		if n > unicode.MaxRune {
+			return "", Fatalf("punycode overflow")
+		}
+		output = slices.Insert(output, i, rune(n))
+		i++
+	}
+	return string(output), nil
+}
@@ -0,0 +1,71 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestPunycode(t *testing.T) {
+	decoded, err := punycodeDecode("bcher-kva")
+	require.Nil(t, err)
+	require.Equal(t, "bücher", decoded)
+	require.Equal(t, "pаypal.com", unicodeDomain("xn--pypal-4ve.com"))
+	_, err = punycodeDecode("bcher-k!a")
+	require.NotNil(t, err)
+}
+
+func TestEditDistance(t *testing.T) {
+	require.Equal(t, 0, editDistance("example", "example", 1))
+	require.Equal(t, 1, editDistance("example", "exmaple", 1))
+	require.Equal(t, 1, editDistance("example", "examples", 1))
+	require.Equal(t, 2, editDistance("example", "elpmaxe", 1))
+}
+
+func TestLookalikeMatch(t *testing.T) {
+	initTestConfig(t)
+	ViperSet("lookalike.domains", []string{"paypal.com", "example.com", "bank.example"})
+	defer ViperSet("lookalike.domains", nil)
+	contacts := NewContactIndex()
+	contacts.Add(&Contact{Book: "work", Address: "pat@corp-mail.example"})
+	index := LoadLookalikeIndex(contacts)
+
+	cases := map[string]string{
+		"paypal.com":          "",
+		"xn--pypal-4ve.com":   "paypal.com homoglyph,mixed-script",
+		"paypa1.com":          "paypal.com homoglyph",
+		"examp1e.com":         "example.com homoglyph",
+		"example.net":         "example.com tld-swap",
+		"exmaple.com":         "example.com typo",
+		"corp-mall.example":   "corp-mail.example typo",
+		"billing.examp1e.com": "example.com homoglyph",
+		"mail.exmaple.com":    "example.com typo",
+		"secure.paypal.net":   "paypal.com tld-swap",
+		"mail.example.com":    "",
+		"bank.example.net":    "example.com tld-swap",
+		"bnak.example":        "",
+		"unrelated.example":   "",
+		"mail.unrelated.org":  "",
+	}
+	for domain, expected := range cases {
+		legit, reasons := index.Match(domain)
+		result := ""
+		if legit != "" {
+			result = legit + " " + strings.Join(reasons, ",")
+		}
+		require.Equal(t, expected, result, domain)
+	}
+}
+
+func TestLookalikeHeader(t *testing.T) {
+	initTestConfig(t)
+	ViperSet("lookalike.domains", []string{"example.com"})
+	defer ViperSet("lookalike.domains", nil)
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/billing@examp1e.com/": scanResponse(""),
+	}}
+	scanner, output := newTestScanner(t, "From: billing@examp1e.com\n\nbody\n", client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "example.com", scanner.Lookalike)
+	require.Equal(t, "example.com reason=homoglyph", outputHeader(output, "X-FilterBooks-Lookalike"))
+}
@@ -0,0 +1,145 @@
+// static override lists consulted before the filterctld lookup
+package scanner
+
+import (
+	"fmt"
+	"log"
+	"sort"
+)
+
+const (
+	OverrideDeny  = "deny"
+	OverrideAllow = "allow"
+	OverrideBook  = "book"
+)
+
+var overrideActionRank = map[string]int{
+	OverrideDeny:  0,
+	OverrideAllow: 1,
+	OverrideBook:  2,
+}
+
+type OverrideBookConfig struct {
+	Book  string   `mapstructure:"book"`
+	Match []string `mapstructure:"match"`
+}
+
+type OverrideConfig struct {
+	Deny  []string             `mapstructure:"deny"`
+	Allow []string             `mapstructure:"allow"`
+	Books []OverrideBookConfig `mapstructure:"books"`
+	File  string               `mapstructure:"file"`
+}
+
+type Override struct {
+	Action  string
+	Book    string
+	Pattern *Pattern
+	Source  string
+}
+
+// Overrides are held in precedence order: the most specific pattern kind
+// wins (exact, domain, glob, regex); for equally specific patterns deny
+// beats allow which beats book; remaining ties go to the config file
+// before the override file, in the order listed
+type Overrides []*Override
+
+func LoadOverrides() (Overrides, error) {
+	var config OverrideConfig
+	err := viperUnmarshal("overrides", &config)
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := config.overrides("config")
+	if err != nil {
+		return nil, err
+	}
+	if config.File != "" {
+		var fileConfig OverrideConfig
+		err := readConfigFile(config.File, &fileConfig)
+		if err != nil {
+			return nil, err
+		}
+		fileOverrides, err := fileConfig.overrides(config.File)
+		if err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, fileOverrides...)
+	}
+	sort.SliceStable(overrides, func(i, j int) bool {
+		a, b := overrides[i], overrides[j]
+		if a.Pattern.Kind != b.Pattern.Kind {
+			return a.Pattern.Kind < b.Pattern.Kind
+		}
+		return overrideActionRank[a.Action] < overrideActionRank[b.Action]
+	})
+	return overrides, nil
+}
+
+func (c *OverrideConfig) overrides(source string) (Overrides, error) {
+	overrides := Overrides{}
+	add := func(action, book string, texts []string) error {
+		patterns, err := NewPatterns(texts)
+		if err != nil {
+			return Fatalf("%s: %v", source, err)
+		}
+		for _, pattern := range patterns {
+			overrides = append(overrides, &Override{Action: action, Book: book, Pattern: pattern, Source: source})
+		}
+		return nil
+	}
+	err := add(OverrideDeny, "", c.Deny)
+	if err != nil {
+		return nil, err
+	}
+	err = add(OverrideAllow, "", c.Allow)
+	if err != nil {
+		return nil, err
+	}
+	for _, book := range c.Books {
+		if book.Book == "" {
+			return nil, Fatalf("%s: override book entry missing book name", source)
+		}
+		err := add(OverrideBook, book.Book, book.Match)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return overrides, nil
+}
+
+func (o Overrides) Match(address string) *Override {
+	for _, override := range o {
+		if override.Pattern.Match(address) {
+			return override
+		}
+	}
+	return nil
+}
+
+func (o *Override) String() string {
+	action := o.Action
+	if o.Book != "" {
+		action += " " + o.Book
+	}
+	return fmt.Sprintf("%s %s source=%s", action, o.Pattern, o.Source)
+}
+
+// set the scan result from the matching override, returning false if none match
+func (s *Scanner) applyOverride(address string) bool {
+	override := s.overrides.Match(address)
+	if override == nil {
+		return false
+	}
+	if s.verbose {
+		log.Printf("override: %s\n", override)
+	}
+	s.Override = override
+	s.Whitelisted = override.Action != OverrideDeny
+	s.Book = override.Book
+	s.Books = []string{}
+	if override.Book != "" {
+		s.Books = append(s.Books, override.Book)
+	}
+	return true
+}
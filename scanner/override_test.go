@@ -0,0 +1,67 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPatternMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		kind    PatternKind
+		value   string
+		match   bool
+	}{
+		{"User@Example.com", PatternExact, "user@example.com", true},
+		{"user@example.com", PatternExact, "user@example.org", false},
+		{"@example.com", PatternDomain, "anyone@EXAMPLE.com", true},
+		{"@example.com", PatternDomain, "anyone@sub.example.com", false},
+		{"*@*.example.com", PatternGlob, "anyone@sub.example.com", true},
+		{"MAILER-DAEMON@*", PatternGlob, "mailer-daemon@host.example.com", true},
+		{"/^alerts-[0-9]+@/", PatternRegex, "alerts-42@example.com", true},
+		{"/^alerts-[0-9]+@/", PatternRegex, "alerts@example.com", false},
+	}
+	for _, c := range cases {
+		p, err := NewPattern(c.pattern)
+		require.Nil(t, err)
+		require.Equal(t, c.kind, p.Kind, c.pattern)
+		require.Equal(t, c.match, p.Match(c.value), "%s %s", c.pattern, c.value)
+	}
+	_, err := NewPattern("/[/")
+	require.NotNil(t, err)
+}
+
+func TestOverridePrecedence(t *testing.T) {
+	initTestConfig(t)
+	overrideFile := filepath.Join(t.TempDir(), "overrides.yaml")
+	err := os.WriteFile(overrideFile, []byte("allow:\n  - ops@vendor.example\n"), 0600)
+	require.Nil(t, err)
+	ViperSet("overrides", map[string]any{
+		"deny":  []string{"@gmail.com", "ops@vendor.example"},
+		"allow": []string{"mom@gmail.com", "*@vendor.example"},
+		"books": []map[string]any{
+			{"book": "Family", "match": []string{"@family.example", "mom@gmail.com"}},
+		},
+		"file": overrideFile,
+	})
+	defer ViperSet("overrides", nil)
+
+	overrides, err := LoadOverrides()
+	require.Nil(t, err)
+
+	match := func(address string) string {
+		o := overrides.Match(address)
+		if o == nil {
+			return ""
+		}
+		return o.String()
+	}
+	require.Equal(t, "allow exact:mom@gmail.com source=config", match("mom@gmail.com"))
+	require.Equal(t, "deny domain:@gmail.com source=config", match("stranger@gmail.com"))
+	require.Equal(t, "book Family domain:@family.example source=config", match("dad@family.example"))
+	require.Equal(t, "deny exact:ops@vendor.example source=config", match("ops@vendor.example"))
+	require.Equal(t, "allow glob:*@vendor.example source=config", match("sales@vendor.example"))
+	require.Equal(t, "", match("nobody@example.org"))
+}
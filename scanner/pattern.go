@@ -0,0 +1,107 @@
+// address and header value patterns
+package scanner
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type PatternKind int
+
+// pattern kinds in order of decreasing specificity
+const (
+	PatternExact PatternKind = iota
+	PatternDomain
+	PatternGlob
+	PatternRegex
+)
+
+var patternKindNames = []string{"exact", "domain", "glob", "regex"}
+
+func (k PatternKind) String() string {
+	return patternKindNames[k]
+}
+
+// Pattern matches a string case-insensitively using one of these forms:
+//
+//	/expression/       regular expression
+//	@example.com       any address in the domain
+//	*@*.example.com    glob with * and ? wildcards
+//	user@example.com   exact match
+type Pattern struct {
+	Text  string
+	Kind  PatternKind
+	regex *regexp.Regexp
+	value string
+}
+
+func NewPattern(text string) (*Pattern, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, Fatalf("empty pattern")
+	}
+	p := Pattern{Text: text}
+	switch {
+	case len(text) > 1 && strings.HasPrefix(text, "/") && strings.HasSuffix(text, "/"):
+		p.Kind = PatternRegex
+		regex, err := regexp.Compile("(?i)" + text[1:len(text)-1])
+		if err != nil {
+			return nil, Fatalf("invalid pattern '%s': %v", text, err)
+		}
+		p.regex = regex
+	case strings.ContainsAny(text, "*?"):
+		p.Kind = PatternGlob
+		p.regex = globRegexp(text)
+	case strings.HasPrefix(text, "@"):
+		p.Kind = PatternDomain
+		p.value = strings.ToLower(text)
+	default:
+		p.Kind = PatternExact
+		p.value = strings.ToLower(text)
+	}
+	return &p, nil
+}
+
+func NewPatterns(texts []string) ([]*Pattern, error) {
+	patterns := []*Pattern{}
+	for _, text := range texts {
+		p, err := NewPattern(text)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+func globRegexp(glob string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("(?i)^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String())
+}
+
+func (p *Pattern) Match(value string) bool {
+	switch p.Kind {
+	case PatternRegex, PatternGlob:
+		return p.regex.MatchString(value)
+	case PatternDomain:
+		return strings.HasSuffix(strings.ToLower(value), p.value)
+	}
+	return strings.ToLower(value) == p.value
+}
+
+func (p *Pattern) String() string {
+	return fmt.Sprintf("%s:%s", p.Kind, p.Text)
+}
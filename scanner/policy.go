@@ -0,0 +1,178 @@
+// classification policies written in the expression language
+package scanner
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+)
+
+type PolicyConfig struct {
+	Name   string `mapstructure:"name"`
+	When   string `mapstructure:"when"`
+	Tag    string `mapstructure:"tag"`
+	Header string `mapstructure:"header"`
+	Book   string `mapstructure:"book"`
+}
+
+type Policy struct {
+	PolicyConfig
+	when *Expr
+}
+
+type Policies []*Policy
+
+// PolicyVariables returns the names of the variables available to policy expressions
+func PolicyVariables() []string {
+	names := []string{}
+	for name := range (&Scanner{}).policyVars() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadPolicies compiles the configured policies, failing on any invalid expression
+func LoadPolicies() (Policies, error) {
+	configs := []PolicyConfig{}
+	err := viperUnmarshal("policies", &configs)
+	if err != nil {
+		return nil, err
+	}
+	variables := PolicyVariables()
+	policies := Policies{}
+	for i, config := range configs {
+		if config.Name == "" {
+			return nil, Fatalf("policy %d: missing name", i+1)
+		}
+		if config.Tag == "" && config.Header == "" && config.Book == "" {
+			return nil, Fatalf("policy %s: no tag, header or book", config.Name)
+		}
+		if config.Header != "" {
+			name, _, found := strings.Cut(config.Header, ":")
+			if !found || strings.TrimSpace(name) == "" || strings.ContainsAny(name, " \t") {
+				return nil, Fatalf("policy %s: invalid header '%s'", config.Name, config.Header)
+			}
+		}
+		when, err := CompileExpr(config.When, variables)
+		if err != nil {
+			return nil, Fatalf("policy %s: %v", config.Name, err)
+		}
+		policies = append(policies, &Policy{PolicyConfig: config, when: when})
+	}
+	return policies, nil
+}
+
+// the values of the policy expression variables for the scanned message
+func (s *Scanner) policyVars() map[string]any {
+	override := ""
+	if s.Override != nil {
+		override = s.Override.Action
+	}
+	impersonation := ""
+	if s.Impersonation != nil {
+		impersonation = s.Impersonation.Address
+	}
+	identity := ""
+	if s.Identity != nil {
+		identity = s.Identity.Header
+	}
+	bounced := []string{}
+	bounceBooks := []string{}
+	if s.Bounce != nil {
+		for _, recipient := range s.Bounce.Recipients {
+			bounced = append(bounced, recipient.Address)
+			if recipient.Book != "" && !slices.Contains(bounceBooks, recipient.Book) {
+				bounceBooks = append(bounceBooks, recipient.Book)
+			}
+		}
+	}
+	authenticated := s.authConfig != nil && len(s.authConfig.Require) > 0 && s.Authenticated()
+	rules := []string{}
+	for _, rule := range s.Matched {
+		rules = append(rules, rule.Name)
+	}
+	return map[string]any{
+		"sender":            s.Sender,
+		"recipient":         s.Recipient,
+		"address":           s.Address,
+		"from":              s.From,
+		"from_name":         s.FromName,
+		"to":                s.To,
+		"message_id":        s.MessageId,
+		"subject":           s.HeaderValue("subject"),
+		"whitelisted":       s.Whitelisted,
+		"book":              s.Book,
+		"books":             slices.Clone(s.Books),
+		"override":          override,
+		"spf":               s.Auth["spf"],
+		"dkim":              s.Auth["dkim"],
+		"dmarc":             s.Auth["dmarc"],
+		"authenticated":     authenticated,
+		"unauthenticated":   s.Unauthenticated,
+		"impersonation":     impersonation,
+		"lookalike":         s.Lookalike,
+		"spoofed":           s.Spoofed,
+		"reply_to":          slices.Clone(s.ReplyTo),
+		"reply_to_unlisted": slices.Clone(s.ReplyToUnlisted),
+		"sender_header":     s.SenderHeader,
+		"identity":          identity,
+		"list_id":           s.ListId,
+		"list_post":         s.ListPost,
+		"list_book":         s.ListBook,
+		"auto":              slices.Clone(s.Auto),
+		"bounced":           bounced,
+		"bounce_books":      bounceBooks,
+		"bulk":              slices.Clone(s.Bulk),
+		"to_list":           slices.Clone(s.ToList),
+		"cc_list":           slices.Clone(s.CcList),
+		"addressed":         s.Addressed,
+		"in_reply_to":       s.InReplyTo,
+		"detail":            s.Detail,
+		"leak":              s.Leak,
+		"first_contact":     s.FirstContact,
+		"flood":             slices.Clone(s.Flood),
+		"duplicate":         s.Duplicate,
+		"score":             float64(s.Score),
+		"trust":             s.Trust,
+		"rules":             rules,
+		"tags":              slices.Clone(s.Tags),
+	}
+}
+
+// evaluate all policies against the scanned message and apply the outputs of those that hold
+func (s *Scanner) applyPolicies() {
+	env := ExprEnv{Vars: s.policyVars(), Headers: s.HeaderValues}
+	names := []string{}
+	for _, policy := range s.policies {
+		match, err := policy.when.Test(&env)
+		if err != nil {
+			Warning("policy %s: %v", policy.Name, err)
+			continue
+		}
+		if !match {
+			continue
+		}
+		if s.verbose {
+			log.Printf("policy %s: %s\n", policy.Name, policy.when)
+		}
+		names = append(names, policy.Name)
+		if policy.Tag != "" {
+			s.addTag(policy.Tag)
+		}
+		if policy.Header != "" {
+			s.AddHeaderLine(policy.Header)
+		}
+		if policy.Book != "" {
+			s.Book = policy.Book
+			if !slices.Contains(s.Books, policy.Book) {
+				s.Books = append(s.Books, policy.Book)
+			}
+		}
+	}
+	if len(names) > 0 {
+		s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Policies: %s", strings.Join(names, ",")))
+	}
+}
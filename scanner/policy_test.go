@@ -0,0 +1,38 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestPolicies(t *testing.T) {
+	initTestConfig(t)
+	ViperSet("policies", []map[string]any{
+		{"name": "family-subject", "when": `book == "family" && subject =~ "(?i)urgent"`, "tag": "suspicious"},
+		{"name": "work-domain", "when": `domain(from) == "example.com" && !("work" in books)`, "book": "work", "header": "X-Work: yes"},
+		{"name": "never", "when": `override != ""`, "tag": "override"},
+	})
+	defer ViperSet("policies", nil)
+
+	message := "From: sender@example.com\nSubject: URGENT wire transfer\n\nbody\n"
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/sender@example.com/": scanResponse("family", "family"),
+	}}
+	scanner, output := newTestScanner(t, message, client)
+	err := scanner.Scan()
+	require.Nil(t, err)
+	require.Equal(t, "family-subject,work-domain", outputHeader(output, "X-FilterBooks-Policies"))
+	require.Equal(t, "suspicious", outputHeader(output, "X-FilterBooks-Tags"))
+	require.Equal(t, "yes", outputHeader(output, "X-Work"))
+	require.Equal(t, "work", outputHeader(output, "X-FilterBook"))
+	require.Equal(t, "family,work", outputHeader(output, "X-FilterBooks"))
+
+	ViperSet("policies", []map[string]any{{"name": "typo", "when": "whitelistd", "tag": "x"}})
+	_, err = LoadPolicies()
+	require.ErrorContains(t, err, "policy typo")
+	require.ErrorContains(t, err, "unknown variable 'whitelistd'")
+
+	ViperSet("policies", []map[string]any{{"name": "no-output", "when": "whitelisted"}})
+	_, err = LoadPolicies()
+	require.ErrorContains(t, err, "no tag, header or book")
+}
@@ -0,0 +1,86 @@
+// resolution of the primary book of a sender in several books
+package scanner
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	ConflictPriority = "priority"
+	ConflictSpecific = "specific"
+	ConflictAll      = "all"
+)
+
+type BooksConfig struct {
+	Priority       []string              `mapstructure:"priority"`
+	Conflict       string                `mapstructure:"conflict"`
+	Actions        map[string]BookAction `mapstructure:"actions"`
+	ReplaceHeaders bool                  `mapstructure:"replace_headers"`
+}
+
+func LoadBooksConfig() (*BooksConfig, error) {
+	var config BooksConfig
+	err := viperUnmarshal("books", &config)
+	if err != nil {
+		return nil, err
+	}
+	switch config.Conflict {
+	case "":
+		config.Conflict = ConflictPriority
+	case ConflictPriority, ConflictSpecific, ConflictAll:
+	default:
+		return nil, Fatalf("books.conflict: expected '%s', '%s' or '%s', got '%s'", ConflictPriority, ConflictSpecific, ConflictAll, config.Conflict)
+	}
+	if config.Conflict == ConflictSpecific && ViperGetString("contacts.file") == "" {
+		Warning("books.conflict is '%s' but contacts.file is not set; books are resolved by priority", ConflictSpecific)
+	}
+	// book names are compared without case, as the actions keys are
+	for i, book := range config.Priority {
+		config.Priority[i] = strings.ToLower(book)
+	}
+	err = validateBookActions(config.Actions)
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// the position of a book in the priority list, with unlisted books after all listed ones
+func (c *BooksConfig) rank(book string) int {
+	if i := slices.Index(c.Priority, strings.ToLower(book)); i >= 0 {
+		return i
+	}
+	return len(c.Priority)
+}
+
+// order the books of a sender in several books and choose the primary book by the conflict policy;
+// without a priority list or contacts to consult the server's book is kept
+func (s *Scanner) resolveBooks() {
+	if len(s.Books) < 2 || (len(s.booksConfig.Priority) == 0 && s.booksConfig.Conflict != ConflictSpecific) {
+		return
+	}
+	books := slices.Clone(s.Books)
+	sort.SliceStable(books, func(i, j int) bool { return s.booksConfig.rank(books[i]) < s.booksConfig.rank(books[j]) })
+	reason := ""
+	primary := books[0]
+	if s.booksConfig.Conflict == ConflictSpecific {
+		// prefer a book listing the exact From address in the contacts file
+		for _, book := range books {
+			if slices.ContainsFunc(s.contacts.ByAddress(s.From), func(c *Contact) bool { return strings.EqualFold(c.Book, book) }) {
+				primary = book
+				reason = " contact=" + s.From
+				break
+			}
+		}
+	}
+	if s.verbose {
+		log.Printf("books %v resolved to %s by %s\n", s.Books, primary, s.booksConfig.Conflict)
+	}
+	s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Resolution: %s conflict=%s books=%s%s", primary, s.booksConfig.Conflict, strings.Join(books, ","), reason))
+	s.Book = primary
+	s.Books = books
+}
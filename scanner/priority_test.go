@@ -0,0 +1,66 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func outputHeaders(output string, name string) []string {
+	values := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		if line == "" {
+			break
+		}
+		key, value, _ := strings.Cut(line, ":")
+		if strings.EqualFold(key, name) {
+			values = append(values, strings.TrimSpace(value))
+		}
+	}
+	return values
+}
+
+func TestBooksConfig(t *testing.T) {
+	initTestConfig(t)
+	defer ViperSet("books", nil)
+	ViperSet("books.conflict", "random")
+	_, err := LoadBooksConfig()
+	require.NotNil(t, err)
+}
+
+func TestResolveBooks(t *testing.T) {
+	initTestConfig(t)
+	writeContacts(t)
+	ViperSet("books.priority", []string{"Family", "work"})
+	defer ViperSet("contacts.file", "")
+	defer ViperSet("books", nil)
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/jane@example.com/": scanResponse("newsletters", "newsletters", "work", "family"),
+		"/filterctl/scan/owner@example.org/pat@corp.example/": scanResponse("newsletters", "newsletters", "family", "work"),
+	}}
+
+	scanner, output := newTestScanner(t, "From: jane@example.com\n\nbody\n", client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "family conflict=priority books=family,work,newsletters", outputHeader(output, "X-FilterBooks-Resolution"))
+	require.Equal(t, []string{"family"}, outputHeaders(output.String(), "X-FilterBook"))
+	require.Equal(t, "family,work,newsletters", outputHeader(output, "X-FilterBooks"))
+
+	ViperSet("books.conflict", "specific")
+	scanner, output = newTestScanner(t, "From: pat@corp.example\n\nbody\n", client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "work conflict=specific books=family,work,newsletters contact=pat@corp.example", outputHeader(output, "X-FilterBooks-Resolution"))
+	require.Equal(t, "work", scanner.Book)
+
+	ViperSet("books.conflict", "all")
+	scanner, output = newTestScanner(t, "From: jane@example.com\n\nbody\n", client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, []string{"family", "work", "newsletters"}, outputHeaders(output.String(), "X-FilterBook"))
+
+	// without a priority list the server's book is kept
+	ViperSet("books", map[string]any{"conflict": "priority"})
+	scanner, output = newTestScanner(t, "From: jane@example.com\n\nbody\n", client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "", outputHeader(output, "X-FilterBooks-Resolution"))
+	require.Equal(t, "newsletters", scanner.Book)
+	require.Equal(t, []string{"newsletters", "work", "family"}, scanner.Books)
+}
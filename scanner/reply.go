@@ -0,0 +1,134 @@
+// conversation tracking of the Message-Ids of sent messages
+package scanner
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+)
+
+const SENT_BUCKET = "sent"
+
+// Message-Ids older than this many days are pruned from the store unless reply.max_age_days is set
+const DEFAULT_SENT_MAX_AGE_DAYS = 365
+
+// References entries beyond this count are not checked
+const MAX_REFERENCES = 20
+
+var MESSAGE_ID_LIST = regexp.MustCompile(`<([^<>\s]+)>`)
+
+// return the store of the owner, opening it on first use
+func (s *Scanner) openStore() (*Store, error) {
+	if s.store != nil {
+		return s.store, nil
+	}
+	store, err := OpenStore(s.Address)
+	if err != nil {
+		return nil, Fatal(err)
+	}
+	s.store = store
+	return store, nil
+}
+
+// close the store after a check's reads and updates, so the lock is not held across network requests
+func (s *Scanner) releaseStore() {
+	err := s.closeStore()
+	if err != nil {
+		Warning("store: %v", err)
+	}
+}
+
+func (s *Scanner) closeStore() error {
+	if s.store == nil {
+		return nil
+	}
+	err := s.store.Close()
+	s.store = nil
+	if err != nil {
+		return Fatal(err)
+	}
+	return nil
+}
+
+// ScanSent records the Message-Id of a message sent by the owner and writes the message unchanged
+func (s *Scanner) ScanSent() error {
+	err := s.ReadHeader()
+	if err != nil {
+		return Fatal(err)
+	}
+	err = s.setAddress()
+	if err != nil {
+		return Fatal(err)
+	}
+	if s.MessageId != "" {
+		store, err := s.openStore()
+		if err != nil {
+			return Fatal(err)
+		}
+		store.Put(SENT_BUCKET, s.MessageId, "", time.Now())
+		pruned := store.Prune(SENT_BUCKET, maxAge("reply.max_age_days", DEFAULT_SENT_MAX_AGE_DAYS))
+		if s.verbose {
+			log.Printf("recorded sent Message-Id %s, pruned %d\n", s.MessageId, pruned)
+		}
+		err = s.closeStore()
+		if err != nil {
+			return Fatal(err)
+		}
+	} else if s.verbose {
+		log.Println("sent message has no Message-Id")
+	}
+	_, err = s.WriteHeader()
+	if err != nil {
+		return Fatal(err)
+	}
+	_, err = s.WriteMessage()
+	if err != nil {
+		return Fatal(err)
+	}
+	return nil
+}
+
+// return the Message-Ids named by In-Reply-To and References, most recent first
+func (s *Scanner) replyReferences() []string {
+	ids := []string{}
+	for _, match := range MESSAGE_ID_LIST.FindAllStringSubmatch(s.HeaderValue("in-reply-to"), -1) {
+		ids = append(ids, match[1])
+	}
+	references := MESSAGE_ID_LIST.FindAllStringSubmatch(s.HeaderValue("references"), -1)
+	for i := len(references) - 1; i >= 0 && len(references)-i <= MAX_REFERENCES; i-- {
+		ids = append(ids, references[i][1])
+	}
+	return ids
+}
+
+// report a reply to a message the owner sent, whitelisting it if so configured
+func (s *Scanner) checkReply() {
+	if !ViperGetBool("reply.check") {
+		return
+	}
+	ids := s.replyReferences()
+	if len(ids) == 0 {
+		return
+	}
+	store, err := s.openStore()
+	if err != nil {
+		Warning("reply check: %v", err)
+		return
+	}
+	defer s.releaseStore()
+	for _, id := range ids {
+		if store.Get(SENT_BUCKET, id) == nil {
+			continue
+		}
+		s.InReplyTo = id
+		if s.verbose {
+			log.Printf("reply to sent message %s\n", id)
+		}
+		s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Reply: <%s>", id))
+		if ViperGetBool("reply.whitelist") && s.Override == nil && !s.forced {
+			s.Whitelisted = true
+		}
+		return
+	}
+}
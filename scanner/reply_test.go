@@ -0,0 +1,39 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestReply(t *testing.T) {
+	initTestConfig(t)
+	ViperSet("cache_dir", t.TempDir())
+	ViperSet("reply.check", true)
+	defer ViperSet("cache_dir", "")
+	defer ViperSet("reply", nil)
+
+	sent := "From: owner@example.org\nTo: new@example.net\nMessage-Id: <thread-1@example.org>\n\nhello\n"
+	scanner, output := newTestScanner(t, sent, nil)
+	require.Nil(t, scanner.ScanSent())
+	require.Equal(t, sent, output.String())
+
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/new@example.net/": scanResponse(""),
+	}}
+	reply := "From: new@example.net\nIn-Reply-To: <other@example.net>\nReferences: <thread-1@example.org>\n\tother@example.net\n\nhi\n"
+	scanner, output = newTestScanner(t, reply, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "<thread-1@example.org>", outputHeader(output, "X-FilterBooks-Reply"))
+	require.Equal(t, "", outputHeader(output, "X-Whitelisted"))
+
+	ViperSet("reply.whitelist", true)
+	scanner, output = newTestScanner(t, reply, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "yes", outputHeader(output, "X-Whitelisted"))
+
+	scanner, output = newTestScanner(t, strings.Replace(reply, "thread-1", "thread-2", 1), client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "", outputHeader(output, "X-FilterBooks-Reply"))
+	require.Equal(t, "", outputHeader(output, "X-Whitelisted"))
+}
@@ -0,0 +1,107 @@
+// Reply-To and Sender header analysis
+package scanner
+
+import (
+	"fmt"
+	"log"
+	"net/mail"
+	"strings"
+)
+
+// reply addresses beyond this count are not looked up
+const MAX_REPLY_TO = 3
+
+// parse an address list header value, falling back to anything that looks like an address
+func parseNamedAddressList(value string) []*mail.Address {
+	addresses := []*mail.Address{}
+	if strings.TrimSpace(value) == "" {
+		return addresses
+	}
+	list, err := mail.ParseAddressList(value)
+	if err != nil {
+		for _, word := range strings.FieldsFunc(value, func(r rune) bool { return strings.ContainsRune(" ,;<>\"'()\t", r) }) {
+			if VALID_EMAIL_ADDRESS.MatchString(word) {
+				addresses = append(addresses, &mail.Address{Address: strings.ToLower(word)})
+			}
+		}
+		return addresses
+	}
+	for _, address := range list {
+		address.Address = strings.ToLower(address.Address)
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
+
+// parse an address list header value, returning the lower case addresses
+func parseAddressList(value string) []string {
+	addresses := []string{}
+	for _, address := range parseNamedAddressList(value) {
+		addresses = append(addresses, address.Address)
+	}
+	return addresses
+}
+
+// return the book lookup result for an address, consulting the overrides first
+func (s *Scanner) lookupAddress(address string) (*ScanResponse, error) {
+	if override := s.overrides.Match(address); override != nil {
+		response := ScanResponse{
+			Response:    Response{Success: true},
+			Whitelisted: override.Action != OverrideDeny,
+			Book:        override.Book,
+			Books:       []string{},
+		}
+		if override.Book != "" {
+			response.Books = append(response.Books, override.Book)
+		}
+		return &response, nil
+	}
+	return s.lookup(s.Address, address)
+}
+
+// Listed returns true if the address is whitelisted or in a book
+func (r *ScanResponse) Listed() bool {
+	return r.Whitelisted || r.Book != ""
+}
+
+// report a whitelisted From paired with an unlisted Reply-To, and a Sender acting on behalf of From
+func (s *Scanner) checkReplyTo() {
+	s.ReplyTo = parseAddressList(s.HeaderValue("reply-to"))
+	if senders := parseAddressList(s.HeaderValue("sender")); len(senders) > 0 {
+		s.SenderHeader = senders[0]
+	}
+	if s.Whitelisted || s.Book != "" {
+		for i, address := range s.ReplyTo {
+			if i >= MAX_REPLY_TO {
+				break
+			}
+			if address == s.From {
+				continue
+			}
+			response, err := s.lookupAddress(address)
+			if err != nil {
+				Warning("Reply-To lookup: %v", err)
+				continue
+			}
+			if !response.Listed() {
+				if s.verbose {
+					log.Printf("Reply-To %s is not in a book\n", address)
+				}
+				s.ReplyToUnlisted = append(s.ReplyToUnlisted, address)
+			}
+		}
+		if len(s.ReplyToUnlisted) > 0 {
+			s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-ReplyTo: unlisted %s", strings.Join(s.ReplyToUnlisted, ",")))
+		}
+	}
+	if s.SenderHeader != "" && s.SenderHeader != s.From {
+		onBehalf := fmt.Sprintf("X-FilterBooks-OnBehalf: %s for %s", s.SenderHeader, s.From)
+		response, err := s.lookupAddress(s.SenderHeader)
+		if err != nil {
+			Warning("Sender lookup: %v", err)
+		} else if response.Book != "" {
+			onBehalf += " book=" + response.Book
+		}
+		s.AddHeaderLine(onBehalf)
+	}
+}
@@ -0,0 +1,45 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseAddressList(t *testing.T) {
+	require.Equal(t, []string{"a@example.com", "b@example.net"}, parseAddressList(`"A" <A@Example.com>, b@example.net`))
+	require.Equal(t, []string{"c@example.com"}, parseAddressList(`broken <c@example.com`))
+	require.Equal(t, []string{}, parseAddressList(""))
+}
+
+func TestReplyTo(t *testing.T) {
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/boss@corp.example/":  scanResponse("work", "work"),
+		"/filterctl/scan/owner@example.org/payme@evil.example/": scanResponse(""),
+		"/filterctl/scan/owner@example.org/pat@corp.example/":   scanResponse("work", "work"),
+		"/filterctl/scan/owner@example.org/bulk@esp.example/":   scanResponse(""),
+	}}
+
+	message := "From: Boss <boss@corp.example>\nReply-To: payme@evil.example, pat@corp.example\n\nbody\n"
+	scanner, output := newTestScanner(t, message, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, []string{"payme@evil.example", "pat@corp.example"}, scanner.ReplyTo)
+	require.Equal(t, "unlisted payme@evil.example", outputHeader(output, "X-FilterBooks-ReplyTo"))
+	require.Equal(t, "yes", outputHeader(output, "X-Whitelisted"))
+
+	// an unlisted From is not reported
+	message = "From: bulk@esp.example\nReply-To: payme@evil.example\n\nbody\n"
+	scanner, output = newTestScanner(t, message, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "", outputHeader(output, "X-FilterBooks-ReplyTo"))
+
+	message = "From: Boss <boss@corp.example>\nSender: bulk@esp.example\n\nbody\n"
+	scanner, output = newTestScanner(t, message, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "bulk@esp.example", scanner.SenderHeader)
+	require.Equal(t, "bulk@esp.example for boss@corp.example", outputHeader(output, "X-FilterBooks-OnBehalf"))
+
+	message = "From: Pat <pat@corp.example>\nSender: Boss <boss@corp.example>\n\nbody\n"
+	scanner, output = newTestScanner(t, message, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "boss@corp.example for pat@corp.example book=work", outputHeader(output, "X-FilterBooks-OnBehalf"))
+}
@@ -0,0 +1,299 @@
+// declarative message rules evaluated before classification
+package scanner
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	RuleSkip     = "skip"
+	RuleBook     = "book"
+	RuleTag      = "tag"
+	RuleFailOpen = "fail_open"
+)
+
+type RuleMatch struct {
+	Sender    string            `mapstructure:"sender" yaml:"sender,omitempty"`
+	Recipient string            `mapstructure:"recipient" yaml:"recipient,omitempty"`
+	Header    map[string]string `mapstructure:"header" yaml:"header,omitempty"`
+	MinSize   int64             `mapstructure:"min_size" yaml:"min_size,omitempty"`
+	MaxSize   int64             `mapstructure:"max_size" yaml:"max_size,omitempty"`
+}
+
+type RuleConfig struct {
+	Name   string    `mapstructure:"name" yaml:"name"`
+	Match  RuleMatch `mapstructure:"match" yaml:"match"`
+	Action string    `mapstructure:"action" yaml:"action"`
+	Book   string    `mapstructure:"book" yaml:"book,omitempty"`
+	Tag    string    `mapstructure:"tag" yaml:"tag,omitempty"`
+}
+
+// used when the config has no rules section
+var DefaultRules = []RuleConfig{
+	{Name: "mailer-daemon", Match: RuleMatch{Sender: "MAILER-DAEMON@*"}, Action: RuleSkip},
+	{Name: "sieve-daemon", Match: RuleMatch{Sender: "SIEVE-DAEMON@*"}, Action: RuleSkip},
+	{Name: "filterctl-request", Match: RuleMatch{Header: map[string]string{"x-filterctl-request-id": "*"}}, Action: RuleSkip},
+}
+
+type Rule struct {
+	RuleConfig
+	sender    *Pattern
+	recipient *Pattern
+	headers   map[string]*Pattern
+}
+
+type Rules []*Rule
+
+func RuleConfigs() ([]RuleConfig, error) {
+	if ViperGet("rules") == nil {
+		return DefaultRules, nil
+	}
+	configs := []RuleConfig{}
+	err := viperUnmarshal("rules", &configs)
+	if err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+func LoadRules() (Rules, error) {
+	configs, err := RuleConfigs()
+	if err != nil {
+		return nil, err
+	}
+	rules := Rules{}
+	for i, config := range configs {
+		rule, err := NewRule(config)
+		if err != nil {
+			return nil, Fatalf("rule %d: %v", i+1, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func NewRule(config RuleConfig) (*Rule, error) {
+	r := Rule{RuleConfig: config, headers: make(map[string]*Pattern)}
+	if r.Name == "" {
+		return nil, Fatalf("missing name")
+	}
+	switch r.Action {
+	case RuleSkip, RuleFailOpen:
+	case RuleBook:
+		if r.Book == "" {
+			return nil, Fatalf("%s: book action requires book", r.Name)
+		}
+	case RuleTag:
+		if r.Tag == "" {
+			return nil, Fatalf("%s: tag action requires tag", r.Name)
+		}
+	default:
+		return nil, Fatalf("%s: unknown action '%s'", r.Name, r.Action)
+	}
+	var err error
+	if r.Match.Sender != "" {
+		r.sender, err = NewPattern(r.Match.Sender)
+		if err != nil {
+			return nil, Fatalf("%s: %v", r.Name, err)
+		}
+	}
+	if r.Match.Recipient != "" {
+		r.recipient, err = NewPattern(r.Match.Recipient)
+		if err != nil {
+			return nil, Fatalf("%s: %v", r.Name, err)
+		}
+	}
+	for name, text := range r.Match.Header {
+		r.headers[strings.ToLower(name)], err = NewPattern(text)
+		if err != nil {
+			return nil, Fatalf("%s: %v", r.Name, err)
+		}
+	}
+	return &r, nil
+}
+
+// return true if all of the rule's conditions hold for the scanned message
+func (r *Rule) Matches(s *Scanner) (bool, error) {
+	if r.sender != nil && !r.sender.Match(s.Sender) {
+		return false, nil
+	}
+	if r.recipient != nil && !r.recipient.Match(s.Recipient) {
+		return false, nil
+	}
+	for name, pattern := range r.headers {
+		if !slices.ContainsFunc(s.HeaderValues(name), pattern.Match) {
+			return false, nil
+		}
+	}
+	if r.Match.MinSize > 0 || r.Match.MaxSize > 0 {
+		size, err := s.MessageSize()
+		if err != nil {
+			return false, Fatal(err)
+		}
+		if r.Match.MinSize > 0 && size < r.Match.MinSize {
+			return false, nil
+		}
+		if r.Match.MaxSize > 0 && size > r.Match.MaxSize {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// skip and book actions end rule evaluation
+func (r *Rule) Final() bool {
+	return r.Action == RuleSkip || r.Action == RuleBook
+}
+
+func (r *Rule) String() string {
+	conditions := []string{}
+	if r.sender != nil {
+		conditions = append(conditions, "sender="+r.sender.String())
+	}
+	if r.recipient != nil {
+		conditions = append(conditions, "recipient="+r.recipient.String())
+	}
+	names := []string{}
+	for name := range r.headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		conditions = append(conditions, fmt.Sprintf("header[%s]=%s", name, r.headers[name]))
+	}
+	if r.Match.MinSize > 0 {
+		conditions = append(conditions, fmt.Sprintf("min_size=%d", r.Match.MinSize))
+	}
+	if r.Match.MaxSize > 0 {
+		conditions = append(conditions, fmt.Sprintf("max_size=%d", r.Match.MaxSize))
+	}
+	action := r.Action
+	switch r.Action {
+	case RuleBook:
+		action += " " + r.Book
+	case RuleTag:
+		action += " " + r.Tag
+	}
+	return fmt.Sprintf("%s: [%s] -> %s", r.Name, strings.Join(conditions, " "), action)
+}
+
+// return true if the rules skip the message on the envelope sender and recipient alone,
+// so it can pass unchanged without its header being read
+func (s *Scanner) envelopeSkip() bool {
+	for _, rule := range s.rules {
+		if len(rule.headers) > 0 || rule.Match.MinSize > 0 || rule.Match.MaxSize > 0 {
+			// a final rule depending on the header decides before any later rule
+			if rule.Final() {
+				return false
+			}
+			continue
+		}
+		if (rule.sender != nil && !rule.sender.Match(s.Sender)) || (rule.recipient != nil && !rule.recipient.Match(s.Recipient)) {
+			continue
+		}
+		if rule.Final() {
+			return rule.Action == RuleSkip
+		}
+	}
+	return false
+}
+
+// evaluate the rules in order, recording the matches and their actions
+func (s *Scanner) applyRules() error {
+	for _, rule := range s.rules {
+		match, err := rule.Matches(s)
+		if err != nil {
+			return Fatal(err)
+		}
+		if !match {
+			continue
+		}
+		if s.verbose {
+			log.Printf("rule %s\n", rule)
+		}
+		s.Matched = append(s.Matched, rule)
+		switch rule.Action {
+		case RuleSkip:
+			s.skip = true
+		case RuleBook:
+			s.forced = true
+			s.Book = rule.Book
+			s.Books = []string{rule.Book}
+		case RuleTag:
+			s.addTag(rule.Tag)
+		case RuleFailOpen:
+			s.failOpen = true
+		}
+		if rule.Final() {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *Scanner) addTag(tag string) {
+	if !slices.Contains(s.Tags, tag) {
+		s.Tags = append(s.Tags, tag)
+	}
+}
+
+func (s *Scanner) addRuleHeaders() {
+	if len(s.Tags) > 0 {
+		s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Tags: %s", strings.Join(s.Tags, ",")))
+	}
+	names := []string{}
+	for _, rule := range s.Matched {
+		names = append(names, rule.Name)
+	}
+	if len(names) > 0 {
+		s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Rules: %s", strings.Join(names, ",")))
+	}
+}
+
+// read the message header and write a report of rule evaluation to w
+func (s *Scanner) TestRules(w io.Writer) error {
+	err := s.ReadHeader()
+	if err != nil {
+		return Fatal(err)
+	}
+	err = s.applyRules()
+	if err != nil {
+		return Fatal(err)
+	}
+	fmt.Fprintf(w, "sender: %s\nrecipient: %s\n", s.Sender, s.Recipient)
+	if s.body != nil {
+		fmt.Fprintf(w, "size: %d\n", s.headerSize+int64(len(s.body)))
+	}
+	final := false
+	for _, rule := range s.rules {
+		status := "no match"
+		switch {
+		case final:
+			status = "not evaluated"
+		case slices.Contains(s.Matched, rule):
+			status = "MATCH"
+			final = rule.Final()
+		}
+		fmt.Fprintf(w, "%-13s %s\n", status, rule)
+	}
+	switch {
+	case s.skip:
+		fmt.Fprintln(w, "result: skip")
+	case s.Book != "":
+		fmt.Fprintf(w, "result: book %s\n", s.Book)
+	default:
+		fmt.Fprintln(w, "result: lookup")
+	}
+	if len(s.Tags) > 0 {
+		fmt.Fprintf(w, "tags: %s\n", strings.Join(s.Tags, ","))
+	}
+	if s.failOpen {
+		fmt.Fprintln(w, "fail_open: true")
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package scanner
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+const testMessage = "From: Sender <sender@example.com>\r\n" +
+	"To: owner@example.org\r\n" +
+	"Subject: folded\r\n" +
+	"  subject line\r\n" +
+	"X-FilterBooks-Override: allow forged\r\n" +
+	"  continuation\r\n" +
+	"Message-Id: <1234@example.com>\r\n" +
+	"\r\n" +
+	"message body\r\n"
+
+func TestDefaultRules(t *testing.T) {
+	client := &testClient{responses: map[string]any{}}
+	scanner, output := newTestScanner(t, testMessage, client)
+	ViperSet("sender", "MAILER-DAEMON@mail.example.com")
+	scanner.Sender = "MAILER-DAEMON@mail.example.com"
+	err := scanner.Scan()
+	require.Nil(t, err)
+	require.Empty(t, client.requests)
+	require.Equal(t, testMessage, output.String())
+
+	// the header of a message skipped on its sender is not read, so long lines pass
+	long := "From: x@example.com\r\nReferences: " + strings.Repeat("<a@example.com> ", 200) + "\r\n\r\nbody\r\n"
+	scanner, output = newTestScanner(t, long, client)
+	scanner.Sender = "MAILER-DAEMON@mail.example.com"
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, long, output.String())
+
+	// nor do they fail a skipped bounce whose header is read for the DSN
+	ViperSet("dsn.parse", true)
+	defer ViperSet("dsn", nil)
+	scanner, output = newTestScanner(t, long, client)
+	scanner.Sender = "MAILER-DAEMON@mail.example.com"
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, long, output.String())
+
+	// a message skipped on a header condition keeps its line endings and headers
+	request := "X-Filterctl-Request-Id: 1234\r\n" + testMessage
+	scanner, output = newTestScanner(t, request, client)
+	require.Nil(t, scanner.Scan())
+	require.Empty(t, client.requests)
+	require.Equal(t, request, output.String())
+}
+
+func TestSkippedBounce(t *testing.T) {
+	bounce := "From: Mail Delivery System <MAILER-DAEMON@localhost>\n" +
+		"Subject: Undelivered Mail Returned to Sender\n\nbody\n"
+	client := &testClient{responses: map[string]any{}}
+	scanner, output := newTestScanner(t, bounce, client)
+	scanner.Sender = "MAILER-DAEMON@mail.example.org"
+	require.Nil(t, scanner.Scan())
+	require.Empty(t, client.requests)
+	require.Equal(t, bounce, output.String())
+
+	// the From parse failure still rejects a message that is not skipped
+	scanner, _ = newTestScanner(t, bounce, client)
+	require.NotNil(t, scanner.Scan())
+}
+
+func TestRules(t *testing.T) {
+	initTestConfig(t)
+	ViperSet("rules", []map[string]any{
+		{"name": "large", "match": map[string]any{"min_size": 100}, "action": "tag", "tag": "large"},
+		{"name": "lookup-down", "action": "fail_open"},
+		{"name": "folded", "match": map[string]any{"header": map[string]string{"Subject": "folded*subject line"}}, "action": "tag", "tag": "folded"},
+		{"name": "orders", "match": map[string]any{"recipient": "owner+orders@*"}, "action": "book", "book": "orders"},
+		{"name": "bounces", "match": map[string]any{"sender": "MAILER-DAEMON@*"}, "action": "skip"},
+	})
+	defer ViperSet("rules", nil)
+
+	client := &testClient{responses: map[string]any{}}
+	scanner, output := newTestScanner(t, testMessage, client)
+	err := scanner.Scan()
+	require.Nil(t, err)
+	require.Equal(t, []string{"GET /filterctl/scan/owner@example.org/sender@example.com/"}, client.requests)
+	require.Equal(t, "lookup failed", outputHeader(output, "X-FilterBooks-Error"))
+	require.Equal(t, "large,folded", outputHeader(output, "X-FilterBooks-Tags"))
+	require.Equal(t, "", outputHeader(output, "X-FilterBooks-Override"))
+	require.True(t, strings.HasSuffix(output.String(), "\n\nmessage body\r\n"))
+
+	client = &testClient{responses: map[string]any{}}
+	scanner, output = newTestScanner(t, testMessage, client)
+	scanner.Recipient = "owner+orders@example.org"
+	err = scanner.Scan()
+	require.Nil(t, err)
+	require.Empty(t, client.requests)
+	require.Equal(t, "orders", outputHeader(output, "X-FilterBook"))
+	require.Equal(t, "large,lookup-down,folded,orders", outputHeader(output, "X-FilterBooks-Rules"))
+
+	scanner, _ = newTestScanner(t, testMessage, client)
+	scanner.Sender = "mailer-daemon@example.com"
+	report := bytes.Buffer{}
+	err = scanner.TestRules(&report)
+	require.Nil(t, err)
+	require.Contains(t, report.String(), "MATCH         bounces: [sender=glob:MAILER-DAEMON@*] -> skip")
+	require.Contains(t, report.String(), "result: skip")
+
+	ViperSet("rules", []map[string]any{{"name": "bad", "action": "explode"}})
+	_, err = LoadRules()
+	require.ErrorContains(t, err, "unknown action 'explode'")
+}
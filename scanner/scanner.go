@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net/mail"
 	"os"
 	"regexp"
 	"strings"
@@ -12,11 +13,6 @@ import (
 
 const Version = "0.1.18"
 
-var SkipSenders []string = []string{
-	"MAILER-DAEMON@",
-	"SIEVE-DAEMON@",
-}
-
 const LINE_BUFLEN = 1024
 
 var BRACKETED_TEXT = regexp.MustCompile(`^.*<([^>]+)>.*$`)
@@ -34,35 +30,101 @@ type ScanResponse struct {
 	Books       []string `json:"Books"`
 }
 
+type Field struct {
+	Name  string
+	Value string
+	Raw   string
+}
+
 type Scanner struct {
-	writer    *os.File
-	reader    *os.File
-	Host      string
-	User      string
-	Sender    string
-	To        string
-	From      string
-	Book      string
-	EOL       string
-	Address   string
-	MessageId string
-	header    []string
-	apiKey    string
-	verbose   bool
-	debug     bool
-	client    APIClient
+	writer          io.Writer
+	reader          io.Reader
+	Host            string
+	User            string
+	Sender          string
+	Recipient       string
+	To              string
+	From            string
+	FromName        string
+	Book            string
+	EOL             string
+	Address         string
+	MessageId       string
+	Whitelisted     bool
+	Books           []string
+	Override        *Override
+	Auth            map[string]string
+	DKIM            []*DKIMResult
+	Impersonation   *Contact
+	Lookalike       string
+	Spoofed         bool
+	ReplyTo         []string
+	ReplyToUnlisted []string
+	SenderHeader    string
+	Identity        *Identity
+	ListId          string
+	ListPost        string
+	ListBook        string
+	Auto            []string
+	Bounce          *Bounce
+	Bulk            []string
+	ToList          []string
+	CcList          []string
+	Addressed       string
+	InReplyTo       string
+	Learned         []string
+	Detail          string
+	Leak            string
+	FirstContact    bool
+	Flood           []string
+	Duplicate       bool
+	Score           int
+	Trust           string
+	Unauthenticated bool
+	Tags            []string
+	Matched         []*Rule
+	header          []string
+	fields          []*Field
+	fromErr         error
+	headerSize      int64
+	rawHeader       []byte
+	headerAdded     bool
+	body            []byte
+	skip            bool
+	forced          bool
+	failOpen        bool
+	apiKey          string
+	verbose         bool
+	debug           bool
+	client          APIClient
+	overrides       Overrides
+	rules           Rules
+	policies        Policies
+	authConfig      *AuthConfig
+	resolver        KeyResolver
+	contacts        *ContactIndex
+	lookalikes      *LookalikeIndex
+	spoofCheck      *SpoofCheck
+	forwarded       *ForwardedConfig
+	store           *Store
+	detailConfig    *DetailConfig
+	floodConfig     *FloodConfig
+	scoreConfig     *ScoreConfig
+	booksConfig     *BooksConfig
 }
 
-func NewScanner(url string, writer, reader *os.File) (*Scanner, error) {
+func NewScanner(url string, writer io.Writer, reader io.Reader) (*Scanner, error) {
 	s := Scanner{
-		writer:  writer,
-		reader:  reader,
-		header:  []string{},
-		EOL:     "\n",
-		Host:    ViperGetString("host"),
-		User:    ViperGetString("user"),
-		Sender:  ViperGetString("sender"),
-		verbose: ViperGetBool("verbose"),
+		writer:    writer,
+		reader:    reader,
+		header:    []string{},
+		fields:    []*Field{},
+		EOL:       "\n",
+		Host:      ViperGetString("host"),
+		User:      ViperGetString("user"),
+		Sender:    ViperGetString("sender"),
+		Recipient: ViperGetString("recipient"),
+		verbose:   ViperGetBool("verbose"),
 	}
 	if s.verbose {
 		log.Printf("filterbooks v%s url=%s host=%s user=%s sender=%s\n", Version, url, s.Host, s.User, s.Sender)
@@ -77,6 +139,55 @@ func NewScanner(url string, writer, reader *os.File) (*Scanner, error) {
 		return nil, Fatalf("missing sender")
 	}
 	var err error
+	s.overrides, err = LoadOverrides()
+	if err != nil {
+		return nil, Fatal(err)
+	}
+	s.rules, err = LoadRules()
+	if err != nil {
+		return nil, Fatal(err)
+	}
+	s.policies, err = LoadPolicies()
+	if err != nil {
+		return nil, Fatal(err)
+	}
+	s.authConfig, err = LoadAuthConfig()
+	if err != nil {
+		return nil, Fatal(err)
+	}
+	s.resolver, err = LoadKeyResolver()
+	if err != nil {
+		return nil, Fatal(err)
+	}
+	s.contacts, err = LoadContacts()
+	if err != nil {
+		return nil, Fatal(err)
+	}
+	s.lookalikes = LoadLookalikeIndex(s.contacts)
+	s.spoofCheck, err = LoadSpoofCheck()
+	if err != nil {
+		return nil, Fatal(err)
+	}
+	s.forwarded, err = LoadForwardedConfig()
+	if err != nil {
+		return nil, Fatal(err)
+	}
+	s.detailConfig, err = LoadDetailConfig()
+	if err != nil {
+		return nil, Fatal(err)
+	}
+	s.floodConfig, err = LoadFloodConfig()
+	if err != nil {
+		return nil, Fatal(err)
+	}
+	s.scoreConfig, err = LoadScoreConfig()
+	if err != nil {
+		return nil, Fatal(err)
+	}
+	s.booksConfig, err = LoadBooksConfig()
+	if err != nil {
+		return nil, Fatal(err)
+	}
 	s.client, err = NewAPIClient("", url, "", "", "", nil)
 	if err != nil {
 		return nil, Fatal(err)
@@ -98,33 +209,77 @@ func (s *Scanner) Close() {
 
 func (s *Scanner) Scan() error {
 
-	for _, prefix := range SkipSenders {
-		if strings.HasPrefix(s.Sender, prefix) {
-			if s.verbose {
-				log.Printf("ignoring %s message\n", s.Sender)
-			}
-			_, err := s.WriteMessage()
-			return err
+	s.skip = s.envelopeSkip()
+	if s.skip && !ViperGetBool("dsn.parse") {
+		if s.verbose {
+			log.Printf("skipping %s message\n", s.Sender)
 		}
+		_, err := s.WriteMessage()
+		if err != nil {
+			return Fatal(err)
+		}
+		return nil
 	}
 
-	_, domain, domainFound := strings.Cut(s.Host, ".")
-	if !domainFound {
-		return Fatalf("failed parsing domain from Host: %s", s.Host)
+	err := s.ReadHeader()
+	if err != nil {
+		return Fatal(err)
 	}
-	s.Address = s.User + "@" + domain
 
-	enable, err := s.ReadHeader()
+	err = s.applyRules()
 	if err != nil {
 		return Fatal(err)
 	}
-	if enable {
-		err := s.ScanAddressBooks(s.Address, s.From)
+
+	autoSkipped := false
+	if !s.skip {
+		s.checkAuto()
+		autoSkipped = s.skip
+	}
+
+	if ViperGetBool("dsn.parse") {
+		err := s.setAddress()
 		if err != nil {
 			return Fatal(err)
 		}
+		err = s.checkDSN()
+		if err != nil {
+			return Fatal(err)
+		}
+	}
+
+	if !s.skip {
+		if s.fromErr != nil {
+			return Fatal(s.fromErr)
+		}
+		err := s.setAddress()
+		if err != nil {
+			return Fatal(err)
+		}
+		err = s.classify()
+		closeErr := s.closeStore()
+		if err != nil {
+			return Fatal(err)
+		}
+		if closeErr != nil {
+			Warning("store: %v", closeErr)
+		}
+	}
+
+	// classify writes the rule headers, but a message skipped by auto.skip_lookup still reports them
+	if autoSkipped {
+		s.addRuleHeaders()
+	}
+
+	var count int64
+	if s.skip && !s.headerAdded {
+		// a skipped message passes unchanged, keeping its line endings and inbound headers
+		var n int
+		n, err = s.writer.Write(s.rawHeader)
+		count = int64(n)
+	} else {
+		count, err = s.WriteHeader()
 	}
-	count, err := s.WriteHeader()
 	if err != nil {
 		return Fatal(err)
 	}
@@ -141,18 +296,71 @@ func (s *Scanner) Scan() error {
 	return nil
 }
 
+// set the owner's address from the user and the domain of the host
+func (s *Scanner) setAddress() error {
+	_, domain, domainFound := strings.Cut(s.Host, ".")
+	if !domainFound {
+		return Fatalf("failed parsing domain from Host: %s", s.Host)
+	}
+	s.Address = s.User + "@" + domain
+	return nil
+}
+
+// determine the book headers for a message not skipped by a rule
+func (s *Scanner) classify() error {
+	s.checkFlood()
+	if !s.forced && !s.applyOverride(s.From) {
+		err := s.scanFrom()
+		if err != nil {
+			if !s.failOpen {
+				return Fatal(err)
+			}
+			log.Printf("fail_open: %v\n", err)
+			s.AddHeaderLine("X-FilterBooks-Error: lookup failed")
+		}
+		s.checkForwarded()
+		s.checkList()
+		s.resolveBooks()
+	}
+	s.checkReply()
+	s.checkImpersonation()
+	s.checkLookalike()
+	s.readAuthResults()
+	err := s.verifyDKIM()
+	if err != nil {
+		return Fatal(err)
+	}
+	s.checkSpoof()
+	s.applyAuthGate()
+	s.checkReplyTo()
+	s.checkAddressed()
+	s.checkDetail()
+	s.checkLeak()
+	s.checkFirstContact()
+	s.checkDuplicate()
+	s.checkBulk()
+	s.computeScore()
+	s.applyPolicies()
+	s.applyBookActions()
+	s.addBookHeaders()
+	s.addRuleHeaders()
+	return nil
+}
+
 func (s *Scanner) AddHeaderLine(headerLine string) {
 	if s.verbose {
 		log.Printf("adding: %s\n", headerLine)
 	}
 	s.header = append([]string{headerLine}, s.header...)
+	s.headerAdded = true
 	return
 }
 
+// read a header line; the line length is only limited for messages not already skipped
 func (s *Scanner) ReadHeaderLine() (string, error) {
-	lineBuf := make([]byte, LINE_BUFLEN)
+	lineBuf := make([]byte, 0, LINE_BUFLEN)
 	byteBuf := make([]byte, 1)
-	for i := 0; i < LINE_BUFLEN; i++ {
+	for i := 0; i < LINE_BUFLEN || s.skip; i++ {
 		count, err := s.reader.Read(byteBuf)
 		if err != nil {
 			return "", Fatal(err)
@@ -160,22 +368,23 @@ func (s *Scanner) ReadHeaderLine() (string, error) {
 		if count != 1 {
 			return "", Fatalf("buffer underflow")
 		}
-		lineBuf[i] = byteBuf[0]
+		lineBuf = append(lineBuf, byteBuf[0])
 		if byteBuf[0] == '\n' {
-			line := lineBuf[:i+1]
-			return string(line), nil
+			return string(lineBuf), nil
 		}
 	}
 	return "", Fatalf("buffer overflow")
 }
 
-func (s *Scanner) ReadHeader() (bool, error) {
-	enable := true
+func (s *Scanner) ReadHeader() error {
+	dropping := false
 	for {
 		line, err := s.ReadHeaderLine()
 		if err != nil {
-			return false, Fatal(err)
+			return Fatal(err)
 		}
+		s.headerSize += int64(len(line))
+		s.rawHeader = append(s.rawHeader, line...)
 		switch {
 		case strings.HasSuffix(line, "\r\n"):
 			line = line[:len(line)-2]
@@ -193,45 +402,122 @@ func (s *Scanner) ReadHeader() (bool, error) {
 				s.EOL = "\r"
 			}
 		default:
-			return false, Fatalf("unexpected line ending: %s\n", HexDump([]byte(line)))
+			return Fatalf("unexpected line ending: %s\n", HexDump([]byte(line)))
 		}
 		lowLine := strings.ToLower(line)
-		includeHeader := true
 		switch {
 		case len(strings.TrimSpace(line)) == 0:
 			s.header = append(s.header, line)
-			return enable, nil
-		case strings.HasPrefix(lowLine, "x-address-book:"):
+			return s.parseFields()
+		case line[0] == ' ' || line[0] == '\t':
+			// continuation of a folded header field
+			if dropping {
+				continue
+			}
+			if len(s.fields) > 0 {
+				field := s.fields[len(s.fields)-1]
+				field.Value += line
+				field.Raw += "\r\n" + line
+			}
+		case isOwnHeader(lowLine):
 			if s.verbose {
 				log.Printf("removing: %s\n", line)
 			}
-			includeHeader = false
-		case strings.HasPrefix(lowLine, "message-id:"):
-			s.MessageId = s.bracketedText(s.headerValue(line))
+			dropping = true
+			continue
+		default:
+			name, value, _ := strings.Cut(line, ":")
+			s.fields = append(s.fields, &Field{Name: strings.TrimSpace(name), Value: value, Raw: line})
+		}
+		dropping = false
+		s.header = append(s.header, line)
+	}
+}
+
+// return true for header lines of the kind written by filterbooks, which
+// are removed from incoming messages so they cannot be forged
+func isOwnHeader(lowLine string) bool {
+	for _, prefix := range []string{"x-address-book:", "x-whitelisted:", "x-filterbook:", "x-filterbooks:", "x-filterbooks-"} {
+		if strings.HasPrefix(lowLine, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// set the scanner values derived from the unfolded header fields
+func (s *Scanner) parseFields() error {
+	for _, field := range s.fields {
+		field.Value = strings.TrimSpace(field.Value)
+		switch strings.ToLower(field.Name) {
+		case "message-id":
+			s.MessageId = s.bracketedText(field.Value)
 			log.Printf("Message-Id: %s\n", s.MessageId)
-		case strings.HasPrefix(lowLine, "to:"):
-			toAddr, err := s.parseEmailAddress(lowLine)
-			if err != nil {
-				return false, Fatal(err)
-			}
-			s.To = toAddr
-		case strings.HasPrefix(lowLine, "x-filterctl-request-id:"):
-			if s.verbose {
-				log.Println("ignoring filterctl request")
+		case "to":
+			// an empty group such as "undisclosed-recipients:;" leaves To unset
+			if addresses := parseAddressList(field.Value); len(addresses) > 0 && s.To == "" {
+				s.To = addresses[0]
 			}
-			enable = false
-		case strings.HasPrefix(lowLine, "from:"):
-			fromAddr, err := s.parseEmailAddress(lowLine)
+		case "from":
+			// a From that fails to parse is an error only for messages not skipped by a rule
+			fromAddr, err := s.parseEmailAddress(strings.ToLower(field.Name + ": " + field.Value))
 			if err != nil {
-				return false, Fatal(err)
+				s.fromErr = err
+				continue
 			}
 			s.From = fromAddr
+			address, err := mail.ParseAddress(field.Value)
+			if err == nil {
+				s.FromName = address.Name
+			}
+		case "list-id":
+			s.ListId = strings.ToLower(s.bracketedText(field.Value))
+		case "list-post":
+			s.ListPost = parseListPost(field.Value)
 		}
-		if includeHeader {
-			s.header = append(s.header, line)
+	}
+	return nil
+}
+
+// return the unfolded values of all header fields with the given name
+func (s *Scanner) HeaderValues(name string) []string {
+	values := []string{}
+	for _, field := range s.fields {
+		if strings.EqualFold(field.Name, name) {
+			values = append(values, field.Value)
 		}
 	}
-	return false, Fatalf("logic error")
+	return values
+}
+
+// return the unfolded value of the first header field with the given name
+func (s *Scanner) HeaderValue(name string) string {
+	values := s.HeaderValues(name)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// buffer the message body, making its size available before it is written
+func (s *Scanner) ReadBody() error {
+	if s.body != nil {
+		return nil
+	}
+	body, err := io.ReadAll(s.reader)
+	if err != nil {
+		return Fatal(err)
+	}
+	s.body = body
+	return nil
+}
+
+func (s *Scanner) MessageSize() (int64, error) {
+	err := s.ReadBody()
+	if err != nil {
+		return 0, Fatal(err)
+	}
+	return s.headerSize + int64(len(s.body)), nil
 }
 
 func (s *Scanner) WriteHeader() (int64, error) {
@@ -250,6 +536,13 @@ func (s *Scanner) WriteHeader() (int64, error) {
 }
 
 func (s *Scanner) WriteMessage() (int64, error) {
+	if s.body != nil {
+		count, err := s.writer.Write(s.body)
+		if err != nil {
+			return 0, Fatal(err)
+		}
+		return int64(count), nil
+	}
 	count, err := io.Copy(s.writer, s.reader)
 	if err != nil {
 		return 0, Fatal(err)
@@ -294,22 +587,46 @@ func (s *Scanner) parseEmailAddress(line string) (string, error) {
 	return "", Fatalf("failed address parse: %s", line)
 }
 
-func (s *Scanner) ScanAddressBooks(username, fromAddress string) error {
-
+func (s *Scanner) lookup(username, address string) (*ScanResponse, error) {
 	var response ScanResponse
-	_, err := s.client.Get(fmt.Sprintf("/filterctl/scan/%s/%s/", username, fromAddress), &response)
+	_, err := s.client.Get(fmt.Sprintf("/filterctl/scan/%s/%s/", username, address), &response)
 	if err != nil {
-		return Fatal(err)
+		return nil, Fatal(err)
 	}
 	if !response.Success {
-		return Fatalf("scan request failed: %v\n", response.Message)
+		return nil, Fatalf("scan request failed: %v\n", response.Message)
+	}
+	return &response, nil
+}
+
+func (s *Scanner) ScanAddressBooks(username, fromAddress string) error {
+	response, err := s.lookup(username, fromAddress)
+	if err != nil {
+		return Fatal(err)
+	}
+	s.Whitelisted = response.Whitelisted
+	s.Book = response.Book
+	s.Books = response.Books
+	return nil
+}
+
+func (s *Scanner) addBookHeaders() {
+	if s.Override != nil {
+		s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Override: %s", s.Override))
 	}
-	if response.Whitelisted {
+	if s.Whitelisted {
 		s.AddHeaderLine("X-Whitelisted: yes")
 	}
-	if response.Book != "" {
-		s.AddHeaderLine(fmt.Sprintf("X-FilterBook: %s", response.Book))
+	if s.booksConfig.Conflict == ConflictAll && s.Book != "" {
+		// file into every book, the primary book first
+		for i := len(s.Books) - 1; i >= 0; i-- {
+			if s.Books[i] != s.Book {
+				s.AddHeaderLine(fmt.Sprintf("X-FilterBook: %s", s.Books[i]))
+			}
+		}
 	}
-	s.AddHeaderLine(fmt.Sprintf("X-FilterBooks: %s", strings.Join(response.Books, ",")))
-	return nil
+	if s.Book != "" {
+		s.AddHeaderLine(fmt.Sprintf("X-FilterBook: %s", s.Book))
+	}
+	s.AddHeaderLine(fmt.Sprintf("X-FilterBooks: %s", strings.Join(s.Books, ",")))
 }
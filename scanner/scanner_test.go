@@ -1,6 +1,9 @@
 package scanner
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"github.com/stretchr/testify/require"
 	"log"
 	"os"
@@ -16,6 +19,89 @@ func setDefault(t *testing.T, key string) {
 	ViperSetDefault(key, value)
 }
 
+func initTestConfig(t *testing.T) {
+	Init("filterbooks", Version, filepath.Join("testdata", "config.yaml"))
+}
+
+// testClient answers filterctld requests from a map of path to response
+type testClient struct {
+	responses map[string]any
+	requests  []string
+	onRequest func()
+}
+
+func (c *testClient) Close() {}
+
+func (c *testClient) Get(path string, response interface{}) (string, error) {
+	return c.request("GET", path, nil, response)
+}
+
+func (c *testClient) Post(path string, request, response interface{}, headers *map[string]string) (string, error) {
+	return c.request("POST", path, request, response)
+}
+
+func (c *testClient) Put(path string, request, response interface{}, headers *map[string]string) (string, error) {
+	return c.request("PUT", path, request, response)
+}
+
+func (c *testClient) Delete(path string, response interface{}) (string, error) {
+	return c.request("DELETE", path, nil, response)
+}
+
+func (c *testClient) request(method, path string, request, response interface{}) (string, error) {
+	c.requests = append(c.requests, method+" "+path)
+	if c.onRequest != nil {
+		c.onRequest()
+	}
+	value, ok := c.responses[path]
+	if !ok {
+		return "", fmt.Errorf("404 Not Found: %s %s", method, path)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), json.Unmarshal(data, response)
+}
+
+func scanResponse(book string, books ...string) ScanResponse {
+	return ScanResponse{
+		Response:    Response{Success: true},
+		Whitelisted: book != "",
+		Book:        book,
+		Books:       books,
+	}
+}
+
+// return a scanner reading message with filterctld requests answered by client
+func newTestScanner(t *testing.T, message string, client *testClient) (*Scanner, *bytes.Buffer) {
+	initTestConfig(t)
+	ViperSet("host", "mail.example.org")
+	ViperSet("user", "owner")
+	ViperSet("sender", "sender@example.com")
+	output := bytes.Buffer{}
+	scanner, err := NewScanner("", &output, strings.NewReader(message))
+	require.Nil(t, err)
+	if client != nil {
+		scanner.client = client
+	}
+	return scanner, &output
+}
+
+// return the value of the named header in a scanned message
+func outputHeader(output *bytes.Buffer, name string) string {
+	for _, line := range strings.Split(output.String(), "\n") {
+		if line == "" {
+			break
+		}
+		key, value, _ := strings.Cut(line, ":")
+		if strings.EqualFold(key, name) {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
 func initViper(t *testing.T) {
 	configFile := filepath.Join("testdata", "config.yaml")
 	if !IsFile(configFile) {
@@ -60,7 +146,7 @@ func TestLookup(t *testing.T) {
 	address := ViperGetString("user") + "@" + domain
 	sender := ViperGetString("sender")
 
-	book, err := scanner.ScanAddressBooks(address, sender)
+	err = scanner.ScanAddressBooks(address, sender)
 	require.Nil(t, err)
-	log.Printf("book=%s\n", book)
+	log.Printf("book=%s\n", scanner.Book)
 }
@@ -0,0 +1,148 @@
+// composite trust score
+package scanner
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// the default weight of each scored signal
+var DEFAULT_SCORE_WEIGHTS = map[string]int{
+	"whitelisted":       50,
+	"book":              30,
+	"auth_pass":         20,
+	"auth_fail":         -30,
+	"reply":             40,
+	"first_contact":     -10,
+	"lookalike":         -50,
+	"impersonation":     -60,
+	"spoofed":           -80,
+	"unauthenticated":   -40,
+	"reply_to_unlisted": -20,
+	"leak":              -20,
+	"flood":             -20,
+	"bulk":              -10,
+}
+
+const DEFAULT_TRUSTED_SCORE = 50
+const DEFAULT_SUSPICIOUS_SCORE = -20
+
+type ScoreThresholds struct {
+	Trusted    *int `mapstructure:"trusted"`
+	Suspicious *int `mapstructure:"suspicious"`
+}
+
+type ScoreConfig struct {
+	Enabled    bool            `mapstructure:"enabled"`
+	Weights    map[string]int  `mapstructure:"weights"`
+	Thresholds ScoreThresholds `mapstructure:"thresholds"`
+	trusted    int
+	suspicious int
+}
+
+func LoadScoreConfig() (*ScoreConfig, error) {
+	var config ScoreConfig
+	err := viperUnmarshal("score", &config)
+	if err != nil {
+		return nil, err
+	}
+	weights := make(map[string]int)
+	for name, weight := range DEFAULT_SCORE_WEIGHTS {
+		weights[name] = weight
+	}
+	for name, weight := range config.Weights {
+		if _, ok := DEFAULT_SCORE_WEIGHTS[name]; !ok {
+			return nil, Fatalf("score.weights: unknown signal '%s'", name)
+		}
+		weights[name] = weight
+	}
+	config.Weights = weights
+	config.trusted = DEFAULT_TRUSTED_SCORE
+	if config.Thresholds.Trusted != nil {
+		config.trusted = *config.Thresholds.Trusted
+	}
+	config.suspicious = DEFAULT_SUSPICIOUS_SCORE
+	if config.Thresholds.Suspicious != nil {
+		config.suspicious = *config.Thresholds.Suspicious
+	}
+	if config.suspicious >= config.trusted {
+		return nil, Fatalf("score.thresholds: suspicious (%d) must be below trusted (%d)", config.suspicious, config.trusted)
+	}
+	return &config, nil
+}
+
+// return the names of the scored signals present in the message
+func (s *Scanner) scoreSignals() []string {
+	authFail := false
+	for _, method := range strings.Fields(AUTH_METHODS) {
+		if result := s.Auth[method]; result == "fail" || result == "softfail" {
+			authFail = true
+		}
+	}
+	authPass := s.Auth["dkim"] == "pass" || s.Auth["dmarc"] == "pass"
+	if len(s.authConfig.Require) > 0 {
+		authPass = s.Authenticated()
+	}
+	present := map[string]bool{
+		"whitelisted":       s.Whitelisted,
+		"book":              s.Book != "",
+		"auth_pass":         authPass,
+		"auth_fail":         authFail,
+		"reply":             s.InReplyTo != "",
+		"first_contact":     s.FirstContact,
+		"lookalike":         s.Lookalike != "",
+		"impersonation":     s.Impersonation != nil,
+		"spoofed":           s.Spoofed,
+		"unauthenticated":   s.Unauthenticated,
+		"reply_to_unlisted": len(s.ReplyToUnlisted) > 0,
+		"leak":              s.Leak != "",
+		"flood":             len(s.Flood) > 0,
+		"bulk":              len(s.Bulk) > 0,
+	}
+	signals := []string{}
+	for name, found := range present {
+		if found {
+			signals = append(signals, name)
+		}
+	}
+	sort.Strings(signals)
+	return signals
+}
+
+// sum the weights of the signals present, reporting the score, its breakdown and the trust level
+func (s *Scanner) computeScore() {
+	if !s.scoreConfig.Enabled {
+		return
+	}
+	score := 0
+	breakdown := []string{}
+	for _, signal := range s.scoreSignals() {
+		weight := s.scoreConfig.Weights[signal]
+		if weight == 0 {
+			continue
+		}
+		score += weight
+		breakdown = append(breakdown, fmt.Sprintf("%s=%+d", signal, weight))
+	}
+	s.Score = score
+	switch {
+	case score >= s.scoreConfig.trusted:
+		s.Trust = "trusted"
+	case score <= s.scoreConfig.suspicious:
+		s.Trust = "suspicious"
+	default:
+		s.Trust = "neutral"
+	}
+	if s.verbose {
+		log.Printf("score %d %s: %s\n", score, s.Trust, strings.Join(breakdown, " "))
+	}
+	if len(breakdown) == 0 {
+		breakdown = append(breakdown, "none")
+	}
+	// header lines are prepended, so the score is added last to appear first
+	s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Trust: %s", s.Trust))
+	s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Score-Detail: %s", strings.Join(breakdown, " ")))
+	s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Score: %d", score))
+}
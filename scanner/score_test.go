@@ -0,0 +1,56 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestScoreConfig(t *testing.T) {
+	initTestConfig(t)
+	defer ViperSet("score", nil)
+	ViperSet("score", map[string]any{"weights": map[string]any{"bogus": 1}})
+	_, err := LoadScoreConfig()
+	require.NotNil(t, err)
+	ViperSet("score", map[string]any{"thresholds": map[string]any{"trusted": 0, "suspicious": 0}})
+	_, err = LoadScoreConfig()
+	require.NotNil(t, err)
+}
+
+func TestScore(t *testing.T) {
+	initTestConfig(t)
+	ViperSet("score", map[string]any{
+		"enabled":    true,
+		"weights":    map[string]any{"book": 25},
+		"thresholds": map[string]any{"trusted": 60},
+	})
+	ViperSet("auth.authserv_id", []string{"mx.example.org"})
+	ViperSet("policies", []map[string]any{{"name": "low", "when": "score < 0", "tag": "low-trust"}})
+	defer ViperSet("score", nil)
+	defer ViperSet("auth", nil)
+	defer ViperSet("policies", nil)
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/jane@example.com/":  scanResponse("family", "family"),
+		"/filterctl/scan/owner@example.org/stranger@shop.net/": scanResponse(""),
+	}}
+
+	message := "Authentication-Results: mx.example.org; dmarc=pass header.from=example.com\nFrom: jane@example.com\n\nbody\n"
+	scanner, output := newTestScanner(t, message, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "95", outputHeader(output, "X-FilterBooks-Score"))
+	require.Equal(t, "auth_pass=+20 book=+25 whitelisted=+50", outputHeader(output, "X-FilterBooks-Score-Detail"))
+	require.Equal(t, "trusted", outputHeader(output, "X-FilterBooks-Trust"))
+
+	message = "Authentication-Results: mx.example.org; spf=fail smtp.mailfrom=shop.net\nFrom: stranger@shop.net\nList-Unsubscribe: <mailto:u@shop.net>\n\nbody\n"
+	scanner, output = newTestScanner(t, message, client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, -40, scanner.Score)
+	require.Equal(t, "auth_fail=-30 bulk=-10", outputHeader(output, "X-FilterBooks-Score-Detail"))
+	require.Equal(t, "suspicious", outputHeader(output, "X-FilterBooks-Trust"))
+	require.Equal(t, "low-trust", outputHeader(output, "X-FilterBooks-Tags"))
+
+	scanner, output = newTestScanner(t, "From: stranger@shop.net\n\nbody\n", client)
+	require.Nil(t, scanner.Scan())
+	require.Equal(t, "0", outputHeader(output, "X-FilterBooks-Score"))
+	require.Equal(t, "none", outputHeader(output, "X-FilterBooks-Score-Detail"))
+	require.Equal(t, "neutral", outputHeader(output, "X-FilterBooks-Trust"))
+}
@@ -0,0 +1,127 @@
+// detection of external messages claiming the owner's domain
+package scanner
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"regexp"
+	"strings"
+)
+
+var RECEIVED_FROM_IP = regexp.MustCompile(`\[(?:IPv6:)?([0-9a-fA-F:.]+)\]`)
+var RECEIVED_BY = regexp.MustCompile(`(?i)\sby\s`)
+var RECEIVED_AUTHENTICATED = regexp.MustCompile(`(?i)\bwith\s+\S*SMTPS?A\b|\bauthenticated\b`)
+
+type SpoofConfig struct {
+	Check    bool     `mapstructure:"check"`
+	Internal []string `mapstructure:"internal"`
+}
+
+type SpoofCheck struct {
+	enabled  bool
+	internal []netip.Prefix
+}
+
+// loopback hosts are always internal
+var DEFAULT_INTERNAL = []string{"127.0.0.0/8", "::1/128"}
+
+func LoadSpoofCheck() (*SpoofCheck, error) {
+	var config SpoofConfig
+	err := viperUnmarshal("spoof", &config)
+	if err != nil {
+		return nil, err
+	}
+	check := SpoofCheck{enabled: config.Check, internal: []netip.Prefix{}}
+	for _, network := range append(DEFAULT_INTERNAL, config.Internal...) {
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(network)
+			if addrErr != nil {
+				return nil, Fatalf("spoof.internal: invalid network '%s'", network)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		check.internal = append(check.internal, prefix.Masked())
+	}
+	return &check, nil
+}
+
+func (c *SpoofCheck) Internal(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range c.internal {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// return the relay address in the from clause of a Received header, and whether it is external
+func (s *Scanner) receivedRelay(received string) (netip.Addr, bool) {
+	clause := received
+	if loc := RECEIVED_BY.FindStringIndex(received); loc != nil {
+		clause = received[:loc[0]]
+	}
+	matches := RECEIVED_FROM_IP.FindStringSubmatch(clause)
+	if matches == nil {
+		return netip.Addr{}, false
+	}
+	addr, err := netip.ParseAddr(matches[1])
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr, !s.spoofCheck.Internal(addr)
+}
+
+// return the address of the first external host in the Received chain, or
+// an empty string if every relay was internal or authenticated submission
+func (s *Scanner) externalOrigin() string {
+	for _, received := range s.HeaderValues("received") {
+		addr, external := s.receivedRelay(received)
+		if !external {
+			continue
+		}
+		if RECEIVED_AUTHENTICATED.MatchString(received) {
+			return ""
+		}
+		return addr.String()
+	}
+	return ""
+}
+
+// warn instead of whitelisting when a message claiming the owner's domain originates outside
+func (s *Scanner) checkSpoof() {
+	if !s.spoofCheck.enabled {
+		return
+	}
+	ownDomain := addressDomain(s.Address)
+	if !domainAligned(addressDomain(s.From), ownDomain) {
+		return
+	}
+	if s.Auth["dkim"] == "pass" || s.Auth["dmarc"] == "pass" {
+		return
+	}
+	reasons := []string{}
+	if origin := s.externalOrigin(); origin != "" {
+		reasons = append(reasons, "origin="+origin)
+	}
+	for _, method := range strings.Fields(AUTH_METHODS) {
+		if result := s.Auth[method]; result == "fail" || result == "softfail" {
+			reasons = append(reasons, method+"="+result)
+		}
+	}
+	if len(reasons) == 0 {
+		return
+	}
+	if s.verbose {
+		log.Printf("own-domain spoof: %s %s\n", s.From, strings.Join(reasons, " "))
+	}
+	s.Spoofed = true
+	s.AddHeaderLine(fmt.Sprintf("X-FilterBooks-Spoof: own-domain %s", strings.Join(reasons, " ")))
+	if !s.forced && s.Override == nil {
+		s.Whitelisted = false
+		s.Book = ""
+		s.Books = []string{}
+	}
+}
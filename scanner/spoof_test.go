@@ -0,0 +1,50 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSpoof(t *testing.T) {
+	initTestConfig(t)
+	ViperSet("spoof", map[string]any{"check": true, "internal": []string{"10.0.0.0/8", "192.0.2.7"}})
+	ViperSet("auth", map[string]any{"authserv_id": "mx.example.org"})
+	defer ViperSet("spoof", nil)
+	defer ViperSet("auth", nil)
+	client := &testClient{responses: map[string]any{
+		"/filterctl/scan/owner@example.org/ceo@example.org/": scanResponse("staff", "staff"),
+	}}
+	scan := func(header string) (*Scanner, string) {
+		scanner, output := newTestScanner(t, header+"From: CEO <ceo@example.org>\n\nbody\n", client)
+		require.Nil(t, scanner.Scan())
+		return scanner, outputHeader(output, "X-FilterBooks-Spoof")
+	}
+
+	external := "Received: from filter.example.org (filter.example.org [10.1.2.3])\n\tby mx.example.org with LMTP\n" +
+		"Received: from attacker.example.net (unknown [203.0.113.9])\n\tby filter.example.org with ESMTP id 123\n"
+	scanner, spoof := scan(external)
+	require.Equal(t, "own-domain origin=203.0.113.9", spoof)
+	require.True(t, scanner.Spoofed)
+	require.False(t, scanner.Whitelisted)
+	require.Equal(t, "", scanner.Book)
+
+	// an override address keeps its whitelist while the spoof is still reported
+	ViperSet("overrides.allow", []string{"ceo@example.org"})
+	scanner, spoof = scan(external)
+	ViperSet("overrides", nil)
+	require.Equal(t, "own-domain origin=203.0.113.9", spoof)
+	require.True(t, scanner.Whitelisted)
+
+	scanner, spoof = scan("Authentication-Results: mx.example.org; spf=fail smtp.mailfrom=example.org\n" +
+		"Received: from relay.example.org (relay.example.org [192.0.2.7]) by mx.example.org\n")
+	require.Equal(t, "own-domain spf=fail", spoof)
+
+	submission := "Received: from laptop (home.example.net [198.51.100.4])\n\tby mx.example.org with ESMTPSA id 456\n"
+	scanner, spoof = scan(submission)
+	require.Equal(t, "", spoof)
+	require.Equal(t, "staff", scanner.Book)
+
+	scanner, spoof = scan("Authentication-Results: mx.example.org; dkim=pass header.d=example.org\n" + external)
+	require.Equal(t, "", spoof)
+	require.True(t, scanner.Whitelisted)
+}
@@ -0,0 +1,187 @@
+// local per-owner state store
+package scanner
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type StoreEntry struct {
+	Value string      `json:"value,omitempty"`
+	Time  time.Time   `json:"time"`
+	Times []time.Time `json:"times,omitempty"`
+}
+
+// Store holds named buckets of keyed entries in a JSON file, locked while open
+type Store struct {
+	Buckets  map[string]map[string]*StoreEntry `json:"buckets"`
+	filename string
+	lock     *os.File
+	dirty    bool
+}
+
+// return the configured cache_dir, defaulting to the filterbooks directory under the user cache directory
+func CacheDir() (string, error) {
+	dir := ViperGetString("cache_dir")
+	if dir != "" {
+		return Expand(dir), nil
+	}
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", Fatal(err)
+	}
+	return filepath.Join(cache, "filterbooks"), nil
+}
+
+// return the retention period set in days by key, or the default number of days
+func maxAge(key string, days int) time.Duration {
+	if ViperGetInt(key) > 0 {
+		days = ViperGetInt(key)
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// OpenStore opens and locks the store file of an owner address, creating it if necessary
+func OpenStore(owner string) (*Store, error) {
+	dir, err := CacheDir()
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, Fatal(err)
+	}
+	store := Store{
+		Buckets:  make(map[string]map[string]*StoreEntry),
+		filename: filepath.Join(dir, owner+".json"),
+	}
+	store.lock, err = os.OpenFile(store.filename+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, Fatal(err)
+	}
+	err = lockFile(store.lock)
+	if err != nil {
+		store.lock.Close()
+		return nil, Fatal(err)
+	}
+	data, err := os.ReadFile(store.filename)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		store.unlock()
+		return nil, Fatal(err)
+	default:
+		err = json.Unmarshal(data, &store)
+		if err != nil {
+			Warning("%s: discarding invalid store: %v", store.filename, err)
+			store.Buckets = make(map[string]map[string]*StoreEntry)
+		}
+	}
+	return &store, nil
+}
+
+func (s *Store) Get(bucket, key string) *StoreEntry {
+	return s.Buckets[bucket][key]
+}
+
+func (s *Store) Put(bucket, key, value string, t time.Time) {
+	if s.Buckets[bucket] == nil {
+		s.Buckets[bucket] = make(map[string]*StoreEntry)
+	}
+	s.Buckets[bucket][key] = &StoreEntry{Value: value, Time: t.UTC()}
+	s.dirty = true
+}
+
+// Record adds an occurrence at t to an entry, dropping those before since and keeping at most
+// the max most recent, and returns the number remaining
+func (s *Store) Record(bucket, key string, t, since time.Time, max int) int {
+	times := []time.Time{t.UTC()}
+	if entry := s.Get(bucket, key); entry != nil {
+		for _, occurrence := range entry.Times {
+			if len(times) >= max {
+				break
+			}
+			if occurrence.After(since) {
+				times = append(times, occurrence)
+			}
+		}
+	}
+	s.Put(bucket, key, "", t)
+	s.Buckets[bucket][key].Times = times
+	return len(times)
+}
+
+func (s *Store) Delete(bucket, key string) {
+	if _, ok := s.Buckets[bucket][key]; ok {
+		delete(s.Buckets[bucket], key)
+		s.dirty = true
+	}
+}
+
+// Prune removes the entries of a bucket older than maxAge, returning the number removed
+func (s *Store) Prune(bucket string, maxAge time.Duration) int {
+	cutoff := time.Now().Add(-maxAge)
+	count := 0
+	for key, entry := range s.Buckets[bucket] {
+		if entry.Time.Before(cutoff) {
+			delete(s.Buckets[bucket], key)
+			count++
+		}
+	}
+	if count > 0 {
+		s.dirty = true
+	}
+	return count
+}
+
+// Trim removes the oldest entries of a bucket holding more than max, returning the number removed
+func (s *Store) Trim(bucket string, max int) int {
+	count := len(s.Buckets[bucket]) - max
+	if max <= 0 || count <= 0 {
+		return 0
+	}
+	keys := make([]string, 0, len(s.Buckets[bucket]))
+	for key := range s.Buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return s.Buckets[bucket][keys[i]].Time.Before(s.Buckets[bucket][keys[j]].Time)
+	})
+	for _, key := range keys[:count] {
+		delete(s.Buckets[bucket], key)
+	}
+	s.dirty = true
+	return count
+}
+
+func (s *Store) unlock() {
+	unlockFile(s.lock)
+	s.lock.Close()
+}
+
+// Close writes the store if it was modified and releases the lock
+func (s *Store) Close() error {
+	defer s.unlock()
+	if !s.dirty {
+		return nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return Fatal(err)
+	}
+	temp := s.filename + ".tmp"
+	err = os.WriteFile(temp, data, 0600)
+	if err != nil {
+		return Fatal(err)
+	}
+	err = os.Rename(temp, s.filename)
+	if err != nil {
+		return Fatal(err)
+	}
+	s.dirty = false
+	return nil
+}
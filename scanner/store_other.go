@@ -0,0 +1,16 @@
+//go:build !unix
+
+package scanner
+
+import (
+	"os"
+)
+
+// file locking is not supported; concurrent deliveries may lose store updates
+func lockFile(file *os.File) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
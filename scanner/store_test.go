@@ -0,0 +1,56 @@
+package scanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	initTestConfig(t)
+	dir := t.TempDir()
+	ViperSet("cache_dir", dir)
+	defer ViperSet("cache_dir", "")
+
+	store, err := OpenStore("owner@example.org")
+	require.Nil(t, err)
+	require.Nil(t, store.Get("sent", "a@example.org"))
+	store.Put("sent", "a@example.org", "", time.Now())
+	store.Put("sent", "old@example.org", "", time.Now().Add(-48*time.Hour))
+	require.Equal(t, 1, store.Prune("sent", 24*time.Hour))
+	require.Nil(t, store.Close())
+
+	store, err = OpenStore("owner@example.org")
+	require.Nil(t, err)
+	require.NotNil(t, store.Get("sent", "a@example.org"))
+	require.Nil(t, store.Get("sent", "old@example.org"))
+
+	// only the most recent occurrences up to the limit are kept
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		store.Record("flood", "example.com", now.Add(time.Duration(i)*time.Second), now.Add(-time.Hour), 5)
+	}
+	require.Len(t, store.Get("flood", "example.com").Times, 5)
+	require.Equal(t, now.Add(99*time.Second).UTC(), store.Get("flood", "example.com").Times[0])
+	store.Delete("flood", "example.com")
+
+	// the oldest entries are evicted beyond the size limit
+	store.Put("sent", "b@example.org", "", time.Now().Add(-time.Hour))
+	store.Put("sent", "c@example.org", "", time.Now().Add(time.Hour))
+	require.Equal(t, 0, store.Trim("sent", 3))
+	require.Equal(t, 1, store.Trim("sent", 2))
+	require.Nil(t, store.Get("sent", "b@example.org"))
+	require.NotNil(t, store.Get("sent", "c@example.org"))
+	require.Nil(t, store.Close())
+	_, err = os.Stat(filepath.Join(dir, "owner@example.org.json"))
+	require.Nil(t, err)
+
+	// an invalid store file is discarded
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "owner@example.org.json"), []byte("{"), 0600))
+	store, err = OpenStore("owner@example.org")
+	require.Nil(t, err)
+	require.Nil(t, store.Get("sent", "a@example.org"))
+	require.Nil(t, store.Close())
+}